@@ -0,0 +1,117 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ConditionForward = "ForwardSatisfied" // 依赖的服务均符合约束
+	ConditionReverse = "ReverseSatisfied" // 符合其他服务对本服务的约束
+	// 工作负载声明了覆盖依赖检查的annotation, 有效期内为True, 过期后为False, 移除annotation后删除
+	ConditionOverridden = "Overridden"
+
+	ReasonSatisfied   = "Satisfied"   // 检查通过
+	ReasonViolated    = "Violated"    // 不符合约束
+	ReasonCheckFailed = "CheckFailed" // 无法完成检查, 如镜像仓库不可用
+	ReasonActive      = "Active"      // 覆盖在有效期内
+	ReasonExpired     = "Expired"     // 覆盖已过期
+	ReasonInvalid     = "Invalid"     // 覆盖的annotation格式错误
+	ReasonMalformed   = "Malformed"   // 工作负载上的依赖约束格式错误
+)
+
+// DependencyStatusSpec 被检查的工作负载
+type DependencyStatusSpec struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// ConstraintSource 声明依赖约束的容器
+type ConstraintSource struct {
+	Container  string `json:"container"`
+	Constraint string `json:"constraint"`
+}
+
+// ResolvedCapability 满足能力依赖约束的服务
+type ResolvedCapability struct {
+	Capability string `json:"capability"`
+	Constraint string `json:"constraint"`
+	Provider   string `json:"provider"`
+	Version    string `json:"version"`
+}
+
+// DependencyStatusStatus 工作负载的依赖检查结果
+type DependencyStatusStatus struct {
+	// 工作负载的版本
+	// +optional
+	Version string `json:"version,omitempty"`
+	// 工作负载对其他服务的依赖约束
+	// +optional
+	Dependences map[string]string `json:"dependences,omitempty"`
+	// 依赖约束由哪些容器声明, 多个容器声明时取交集
+	// +optional
+	DependenceSources map[string][]ConstraintSource `json:"dependenceSources,omitempty"`
+	// 用户在工作负载上声明的依赖约束
+	// +optional
+	UserDependences map[string]string `json:"userDependences,omitempty"`
+	// 工作负载提供的能力及版本
+	// +optional
+	Capabilities map[string]string `json:"capabilities,omitempty"`
+	// 工作负载依赖的能力由哪个服务满足
+	// +optional
+	ResolvedCapabilities []ResolvedCapability `json:"resolvedCapabilities,omitempty"`
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Kind",type=string,JSONPath=`.spec.kind`
+//+kubebuilder:printcolumn:name="Workload",type=string,JSONPath=`.spec.name`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version`
+//+kubebuilder:printcolumn:name="Forward",type=string,JSONPath=`.status.conditions[?(@.type=="ForwardSatisfied")].status`
+//+kubebuilder:printcolumn:name="Reverse",type=string,JSONPath=`.status.conditions[?(@.type=="ReverseSatisfied")].status`
+//+kubebuilder:printcolumn:name="Overridden",type=string,JSONPath=`.status.conditions[?(@.type=="Overridden")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DependencyStatus 工作负载的依赖合规状态, 由合规检查控制器维护, 与工作负载同名空间并随其删除
+type DependencyStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DependencyStatusSpec   `json:"spec,omitempty"`
+	Status DependencyStatusStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DependencyStatusList contains a list of DependencyStatus
+type DependencyStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DependencyStatus `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DependencyStatus{}, &DependencyStatusList{})
+}
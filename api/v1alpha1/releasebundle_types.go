@@ -0,0 +1,60 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelReleaseBundle 命名空间上的label, 值为绑定的ReleaseBundle名称
+const LabelReleaseBundle = "wkm.welljoint.com/release-bundle"
+
+// ReleaseBundleSpec 经过测试的一组服务版本
+type ReleaseBundleSpec struct {
+	// 服务名到版本的映射, 如 wmc: 1.8.1
+	Services map[string]string `json:"services"`
+	// 为true时不允许部署未列出的服务
+	// +optional
+	Strict bool `json:"strict,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Strict",type=boolean,JSONPath=`.spec.strict`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ReleaseBundle 发布包, 命名空间通过wkm.welljoint.com/release-bundle label绑定后,
+// 命名空间下工作负载的版本必须与发布包中的版本一致
+type ReleaseBundle struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ReleaseBundleSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ReleaseBundleList contains a list of ReleaseBundle
+type ReleaseBundleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReleaseBundle `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ReleaseBundle{}, &ReleaseBundleList{})
+}
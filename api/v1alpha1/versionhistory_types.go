@@ -0,0 +1,76 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VersionHistoryEntry 一次版本变更
+type VersionHistoryEntry struct {
+	// 变更时间
+	Time metav1.Time `json:"time"`
+	// 工作负载类型
+	Kind string `json:"kind"`
+	// 变更前的版本, 创建时为空
+	// +optional
+	OldVersion string `json:"oldVersion,omitempty"`
+	// 变更后的版本
+	NewVersion string `json:"newVersion"`
+	// 变更后的依赖约束, 服务名到约束的映射
+	// +optional
+	Dependences map[string]string `json:"dependences,omitempty"`
+	// 发起变更的用户, 取自mutate webhook写入的wkm.welljoint.com/changed-by annotation
+	// +optional
+	User string `json:"user,omitempty"`
+	// 修订描述, 取自kubernetes.io/change-cause annotation
+	// +optional
+	Comment string `json:"comment,omitempty"`
+	// 发起变更的会话ID, 取自wkm.welljoint.com/ssid annotation
+	// +optional
+	Ssid string `json:"ssid,omitempty"`
+}
+
+// VersionHistorySpec 服务的版本变更记录, 按时间先后排列
+type VersionHistorySpec struct {
+	// +optional
+	Entries []VersionHistoryEntry `json:"entries,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VersionHistory 服务的版本历史, 名称与服务名一致, 版本变更写入后由控制器追加
+type VersionHistory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VersionHistorySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VersionHistoryList contains a list of VersionHistory
+type VersionHistoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VersionHistory `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VersionHistory{}, &VersionHistoryList{})
+}
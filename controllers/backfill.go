@@ -0,0 +1,126 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=patch
+
+// Backfill 为已存在的工作负载补充版本label和依赖约束annotation, 返回修改的工作负载数量
+// 只修改metadata, 不修改Pod模板, 因此不会触发滚动更新; namespace为空时处理所有命名空间
+// 修改经过mutate webhook, 可能被注入环境变量或init容器, 因此先以dry run提交, Pod模板会被修改时跳过
+// 豁免的命名空间和对象与webhook一致, 直接跳过
+// 单个工作负载失败(如镜像仓库不可用、被validate webhook拒绝)时记录日志并继续处理其他工作负载
+// options与webhook使用相同的配置, 保证补充的版本和依赖约束与webhook设置的一致
+func Backfill(ctx context.Context, c client.Client, logger logr.Logger, options webhook.Options, namespace string) (int, error) {
+	namespaces := []string{namespace}
+	if namespace == "" {
+		var nsList corev1.NamespaceList
+		if err := c.List(ctx, &nsList); err != nil {
+			return 0, err
+		}
+		namespaces = namespaces[:0]
+		for _, ns := range nsList.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+
+	var patched int
+	var errs []error
+	for _, ns := range namespaces {
+		objsMap, err := webhook.ListWorkloads(ctx, c, logger, ns)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for name, obj := range objsMap {
+			workload, ok := registry.GetWorkload(obj)
+			if !ok || webhook.IsExempt(ctx, c, logger, options, obj) {
+				continue
+			}
+			version, deps, capabilities, err := registry.GetVersionDependenceAndCapability(*workload.Template, options.DefaultPlatform)
+			if err != nil {
+				logger.Info("获取版本和依赖失败", "namespace", ns, "name", name, "err", err)
+				errs = append(errs, err)
+				continue
+			}
+
+			original := obj.DeepCopyObject().(client.Object)
+			registry.SetObjVersion(workload.Meta, version, deps.Constraints())
+			registry.SetObjDependenceSources(workload.Meta, deps.Sources())
+			registry.SetObjCapability(workload.Meta, capabilities)
+			if equality.Semantic.DeepEqual(original.GetLabels(), workload.Meta.GetLabels()) &&
+				equality.Semantic.DeepEqual(original.GetAnnotations(), workload.Meta.GetAnnotations()) {
+				continue
+			}
+			dryRun := obj.DeepCopyObject().(client.Object)
+			if err = c.Patch(ctx, dryRun, client.MergeFrom(original), client.DryRunAll); err != nil {
+				logger.Info("补充版本和依赖失败", "namespace", ns, "name", name, "err", err)
+				errs = append(errs, err)
+				continue
+			}
+			if mutated, ok := registry.GetWorkload(dryRun); !ok || !equality.Semantic.DeepEqual(mutated.Template, workload.Template) {
+				err = fmt.Errorf("补充%s/%s的版本和依赖会修改Pod模板并触发滚动更新, 已跳过", ns, name)
+				logger.Info("补充版本和依赖失败", "namespace", ns, "name", name, "err", err)
+				errs = append(errs, err)
+				continue
+			}
+			// 以dry run时的resourceVersion提交, 对象在此期间被修改时提交失败, 下次补充时重新检查
+			if err = c.Patch(ctx, obj.(client.Object), client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})); err != nil {
+				logger.Info("补充版本和依赖失败", "namespace", ns, "name", name, "err", err)
+				errs = append(errs, err)
+				continue
+			}
+			logger.Info("已补充版本和依赖", "namespace", ns, "name", name, "version", version)
+			patched++
+		}
+	}
+	return patched, utilerrors.NewAggregate(errs)
+}
+
+// Backfiller 在manager中定期执行Backfill, 仅在leader上运行
+type Backfiller struct {
+	Client   client.Client
+	Logger   logr.Logger
+	Options  webhook.Options
+	Interval time.Duration
+}
+
+func (b *Backfiller) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		patched, err := Backfill(ctx, b.Client, b.Logger, b.Options, "")
+		if err != nil {
+			b.Logger.Info("补充版本和依赖未全部完成", "patched", patched, "err", err)
+			return
+		}
+		b.Logger.Info("补充版本和依赖完成", "patched", patched)
+	}, b.Interval)
+	return nil
+}
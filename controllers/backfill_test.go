@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func TestBackfill(t *testing.T) {
+	scheme := newTestScheme(t)
+	host := testutil.NewRegistry(t)
+	image := testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^2.0.0"})
+	wmc := testutil.NewDeployment("wmc", image, "", nil)
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ns, wmc.DeepCopy()).Build()
+	ctx := context.Background()
+
+	patched, err := Backfill(ctx, c, logr.Discard(), webhook.Options{}, "")
+	if err != nil || patched != 1 {
+		t.Fatalf("Backfill() = %d, %v, want 1, nil", patched, err)
+	}
+	var got appsv1.Deployment
+	if err = c.Get(ctx, client.ObjectKeyFromObject(wmc), &got); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Labels[registry.K8sLabelVersion]; v != "1.8.1" {
+		t.Errorf("version label = %q, want %q", v, "1.8.1")
+	}
+	if dep := got.Annotations["ocm"+registry.K8sAnnotationDependence]; dep != "^2.0.0" {
+		t.Errorf("ocm dependence = %q, want %q", dep, "^2.0.0")
+	}
+	if !equality.Semantic.DeepEqual(got.Spec.Template, wmc.Spec.Template) {
+		t.Errorf("pod template changed: %+v", got.Spec.Template)
+	}
+
+	// 已补充的工作负载不再修改
+	if patched, err = Backfill(ctx, c, logr.Discard(), webhook.Options{}, "default"); err != nil || patched != 0 {
+		t.Errorf("Backfill() again = %d, %v, want 0, nil", patched, err)
+	}
+}
+
+// 模拟mutate webhook在修改时向Pod模板注入环境变量
+type injectingClient struct {
+	client.Client
+}
+
+func (c injectingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if deploy, ok := obj.(*appsv1.Deployment); ok {
+		containers := deploy.Spec.Template.Spec.Containers
+		containers[0].Env = append(containers[0].Env, corev1.EnvVar{Name: "OCM_VERSION", Value: "2.3.0"})
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestBackfill_Skip(t *testing.T) {
+	scheme := newTestScheme(t)
+	host := testutil.NewRegistry(t)
+	image := testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^2.0.0"})
+	wmc := testutil.NewDeployment("wmc", image, "", nil)
+	proxy := testutil.NewDeployment("kube-proxy", image, "", nil)
+	proxy.Namespace = "kube-system"
+	exempt := testutil.NewDeployment("cms", image, "", nil)
+	exempt.Labels = map[string]string{"dictator.io/exempt": "true"}
+	options := webhook.Options{Exemptions: webhook.ExemptionConfig{
+		ObjectSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"dictator.io/exempt": "true"}},
+	}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		inject  bool
+		objs    []client.Object
+		wantErr bool
+	}{
+		{name: "exempt", objs: []client.Object{proxy, exempt}},
+		{name: "template changed", inject: true, objs: []client.Object{wmc}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := []client.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+			}
+			for _, obj := range tt.objs {
+				objs = append(objs, obj.DeepCopyObject().(client.Object))
+			}
+			var c client.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+			if tt.inject {
+				c = injectingClient{c}
+			}
+			patched, err := Backfill(ctx, c, logr.Discard(), options, "")
+			if patched != 0 || (err != nil) != tt.wantErr {
+				t.Fatalf("Backfill() = %d, %v, want 0, wantErr %v", patched, err, tt.wantErr)
+			}
+			for _, obj := range tt.objs {
+				var got appsv1.Deployment
+				if err = c.Get(ctx, client.ObjectKeyFromObject(obj), &got); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := got.Labels[registry.K8sLabelVersion]; ok {
+					t.Errorf("%s被修改: %v", got.Name, got.Labels)
+				}
+			}
+		})
+	}
+}
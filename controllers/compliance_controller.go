@@ -0,0 +1,410 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+	"time"
+)
+
+//+kubebuilder:rbac:groups=wkm.welljoint.com,resources=dependencystatuses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=wkm.welljoint.com,resources=dependencystatuses/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+const (
+	EventReasonDrift    = "DependenceDrift"    // 依赖检查由通过变为不通过
+	EventReasonRestored = "DependenceRestored" // 依赖检查恢复通过
+
+	EventReasonOverrideExpired = "OverrideExpired" // 覆盖依赖检查已过期
+)
+
+// ComplianceReconciler 持续检查命名空间下所有工作负载的正向和反向依赖,
+// 检查结果记录在与工作负载对应的DependencyStatus中, 检查结果变为不通过时产生事件
+// 任一工作负载变化时重新检查整个命名空间, 请求中只有命名空间
+type ComplianceReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// 与webhook使用相同的检查配置
+	Options webhook.Options
+	// 定期重新检查的间隔, 用于发现镜像中依赖约束的变化, 为0时只在工作负载变化时检查
+	Interval time.Duration
+}
+
+// 工作负载的版本和依赖约束
+type workloadState struct {
+	obj     client.Object
+	gvk     schema.GroupVersionKind
+	version string
+	deps    map[string]string
+	// 依赖约束由哪些容器声明
+	sources map[string][]registry.ConstraintSource
+	// 用户在工作负载上声明的依赖约束
+	userDeps map[string]string
+	// 工作负载提供的能力
+	capabilities map[string]string
+	// 依赖的能力由哪个服务满足, 正向检查时填充
+	resolutions []registry.CapabilityResolution
+	// 获取依赖约束失败的原因
+	err error
+}
+
+func (r *ComplianceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	objsMap, err := webhook.ListWorkloads(ctx, r.Client, logger, req.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// 与webhook一致, 豁免的对象不检查也不记录状态, 但仍作为其他对象的被依赖服务参与检查
+	exempt := make(map[string]bool)
+	for name, obj := range objsMap {
+		if webhook.IsExempt(ctx, r.Client, logger, r.Options, obj) {
+			exempt[name] = true
+		}
+	}
+	if len(exempt) == len(objsMap) {
+		return ctrl.Result{}, nil
+	}
+
+	states := make(map[string]*workloadState, len(objsMap))
+	objsReverseMap := make(map[string]v12.Object, len(objsMap))
+	for name, obj := range objsMap {
+		state, err := r.getWorkloadState(obj)
+		if err != nil {
+			logger.Info("获取工作负载失败", "name", name, "err", err)
+			continue
+		}
+		if state.err != nil {
+			logger.Info("获取版本和依赖失败", "name", name, "err", state.err)
+		}
+		states[name] = state
+		// 依赖约束可能来自镜像而非对象上的annotation, 以副本参与反向检查
+		reverse := &v12.ObjectMeta{Name: name}
+		registry.SetObjVersion(reverse, state.version, state.deps)
+		for svc, constraint := range state.userDeps {
+			reverse.Annotations[registry.UserDependenceAnnotation(svc)] = constraint
+		}
+		registry.SetObjCapability(reverse, state.capabilities)
+		objsReverseMap[name] = reverse
+	}
+
+	now := time.Now()
+	requeueAfter := r.Interval
+	for name, state := range states {
+		if exempt[name] {
+			continue
+		}
+		var forward v12.Condition
+		if state.err != nil {
+			forward = newCondition(wkmv1alpha1.ConditionForward, state.obj, nil)
+			forward.Status = v12.ConditionUnknown
+			forward.Reason = wkmv1alpha1.ReasonCheckFailed
+			forward.Message = state.err.Error()
+		} else {
+			forward = newCondition(wkmv1alpha1.ConditionForward, state.obj, checkForward(objsMap, objsReverseMap, state))
+		}
+		reverse := newCondition(wkmv1alpha1.ConditionReverse, state.obj, registry.CheckReverseDependence(objsReverseMap, name, state.version))
+		conditions := []v12.Condition{forward, reverse}
+		if override, remaining := overrideCondition(state.obj, now); override != nil {
+			conditions = append(conditions, *override)
+			// 覆盖过期时重新检查
+			if remaining > 0 && (requeueAfter == 0 || remaining < requeueAfter) {
+				requeueAfter = remaining
+			}
+		}
+		if err = r.updateStatus(ctx, state, conditions...); err != nil {
+			logger.Info("更新依赖状态失败", "name", name, "err", err)
+			return ctrl.Result{}, err
+		}
+		if err = r.syncDependenceEnv(ctx, logger, objsMap, state); err != nil {
+			logger.Info("更新注入的被依赖服务版本失败", "name", name, "err", err)
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// 获取工作负载的版本和依赖约束
+// 经过mutate webhook的对象以版本label和依赖约束annotation为准, 否则从镜像仓库获取
+func (r *ComplianceReconciler) getWorkloadState(obj runtime.Object) (*workloadState, error) {
+	workload, ok := registry.GetWorkload(obj)
+	if !ok {
+		return nil, fmt.Errorf("不支持的资源类型%T", obj)
+	}
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	state := &workloadState{obj: obj.(client.Object), gvk: gvk, userDeps: registry.GetObjUserDependence(workload.Meta)}
+	if workload.Meta.GetLabels()[registry.K8sLabelVersion] != "" {
+		state.version = workload.Version()
+		state.deps = registry.GetObjDependence(workload.Meta)
+		state.sources = registry.GetObjDependenceSources(workload.Meta)
+		state.capabilities = registry.GetObjCapability(workload.Meta)
+		return state, nil
+	}
+	version, deps, capabilities, err := registry.GetVersionDependenceAndCapability(*workload.Template, r.Options.DefaultPlatform)
+	state.version = version
+	state.deps = deps.Constraints()
+	state.sources = deps.Sources()
+	state.capabilities = capabilities
+	state.err = err
+	return state, nil
+}
+
+// 工作负载要求注入被依赖服务的版本时, 版本变化后更新Pod模板中的环境变量, 会触发工作负载的滚动更新
+// 只更新Deployment、StatefulSet和DaemonSet, 控制器没有修改自定义工作负载的权限, 自定义工作负载在自身更新时由mutate webhook注入
+func (r *ComplianceReconciler) syncDependenceEnv(ctx context.Context, logger logr.Logger, objs map[string]runtime.Object, state *workloadState) error {
+	obj := state.obj.DeepCopyObject().(client.Object)
+	workload, ok := registry.GetWorkload(obj)
+	if !ok || !webhook.WantsDependenceEnv(workload) {
+		return nil
+	}
+	changed, err := registry.InjectDependenceEnv(objs, workload)
+	if err != nil || !changed {
+		return err
+	}
+	if state.gvk.Group != appsv1.GroupName {
+		logger.Info("被依赖服务的版本已变化, 不更新自定义工作负载, 将在其下次更新时注入", "name", obj.GetName(), "kind", state.gvk.Kind)
+		return nil
+	}
+	if err = r.Patch(ctx, obj, client.MergeFrom(state.obj)); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(state.obj, corev1.EventTypeNormal, webhook.EventReasonDependenceEnv, "被依赖服务的版本: %v", registry.DependenceVersions(objs, workload))
+	return nil
+}
+
+// 依次检查镜像和用户声明的依赖约束, 以及依赖的能力, providers为各服务的副本, 记录了提供的能力
+func checkForward(objs map[string]runtime.Object, providers map[string]v12.Object, state *workloadState) error {
+	if err := registry.ValidateObjDependence(state.obj); err != nil {
+		return err
+	}
+	if err := registry.ValidateUserDependence(state.userDeps, state.deps); err != nil {
+		return err
+	}
+	if err := registry.CheckForwardDependence(objs, state.deps); err != nil {
+		return err
+	}
+	if err := registry.CheckUserForwardDependence(objs, state.userDeps); err != nil {
+		return err
+	}
+	resolutions, err := registry.ResolveCapabilities(providers, state.deps, state.userDeps)
+	state.resolutions = resolutions
+	return err
+}
+
+// 根据检查结果生成condition, err为nil时表示检查通过
+func newCondition(conditionType string, obj client.Object, err error) v12.Condition {
+	condition := v12.Condition{
+		Type:               conditionType,
+		Status:             v12.ConditionTrue,
+		Reason:             wkmv1alpha1.ReasonSatisfied,
+		Message:            "依赖检查通过",
+		ObservedGeneration: obj.GetGeneration(),
+	}
+	if err != nil {
+		condition.Status = v12.ConditionFalse
+		condition.Reason = wkmv1alpha1.ReasonViolated
+		condition.Message = err.Error()
+		var malformed registry.MalformedConstraint
+		if errors.As(err, &malformed) {
+			condition.Reason = wkmv1alpha1.ReasonMalformed
+		}
+	}
+	return condition
+}
+
+// 根据覆盖依赖检查的annotation生成condition, 未声明覆盖时返回nil, 覆盖有效时同时返回剩余的有效期
+func overrideCondition(obj client.Object, now time.Time) (*v12.Condition, time.Duration) {
+	override, err := webhook.GetOverride(obj)
+	if override == nil && err == nil {
+		return nil, 0
+	}
+	condition := &v12.Condition{
+		Type:               wkmv1alpha1.ConditionOverridden,
+		Status:             v12.ConditionFalse,
+		ObservedGeneration: obj.GetGeneration(),
+	}
+	switch {
+	case err != nil:
+		condition.Reason = wkmv1alpha1.ReasonInvalid
+		condition.Message = err.Error()
+	case override.Active(now):
+		condition.Status = v12.ConditionTrue
+		condition.Reason = wkmv1alpha1.ReasonActive
+		condition.Message = fmt.Sprintf("有效期至%s, 原因: %s", override.Expires.Format(time.RFC3339), override.Reason)
+		return condition, override.Expires.Sub(now)
+	default:
+		condition.Reason = wkmv1alpha1.ReasonExpired
+		condition.Message = fmt.Sprintf("已于%s过期, 原因: %s", override.Expires.Format(time.RFC3339), override.Reason)
+	}
+	return condition, 0
+}
+
+// 更新工作负载对应的DependencyStatus, 检查结果变化时产生事件
+func (r *ComplianceReconciler) updateStatus(ctx context.Context, state *workloadState, conditions ...v12.Condition) error {
+	status := &wkmv1alpha1.DependencyStatus{ObjectMeta: v12.ObjectMeta{
+		Namespace: state.obj.GetNamespace(),
+		Name:      StatusName(state.gvk.Kind, state.obj.GetName()),
+	}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, status, func() error {
+		status.Spec = wkmv1alpha1.DependencyStatusSpec{
+			APIVersion: state.gvk.GroupVersion().String(),
+			Kind:       state.gvk.Kind,
+			Name:       state.obj.GetName(),
+		}
+		return controllerutil.SetControllerReference(state.obj, status, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+
+	before := status.Status.DeepCopy()
+	status.Status.Version = state.version
+	status.Status.Dependences = state.deps
+	status.Status.DependenceSources = nil
+	if len(state.sources) > 0 {
+		status.Status.DependenceSources = make(map[string][]wkmv1alpha1.ConstraintSource, len(state.sources))
+	}
+	for svc, sources := range state.sources {
+		for _, source := range sources {
+			status.Status.DependenceSources[svc] = append(status.Status.DependenceSources[svc], wkmv1alpha1.ConstraintSource{
+				Container:  source.Container,
+				Constraint: source.Constraint,
+			})
+		}
+	}
+	status.Status.UserDependences = state.userDeps
+	status.Status.Capabilities = state.capabilities
+	status.Status.ResolvedCapabilities = nil
+	for _, resolution := range state.resolutions {
+		status.Status.ResolvedCapabilities = append(status.Status.ResolvedCapabilities, wkmv1alpha1.ResolvedCapability{
+			Capability: resolution.Capability,
+			Constraint: resolution.Constraint,
+			Provider:   resolution.Provider,
+			Version:    resolution.Version,
+		})
+	}
+	current := make(map[string]bool, len(conditions))
+	for _, condition := range conditions {
+		current[condition.Type] = true
+		previous := meta.FindStatusCondition(status.Status.Conditions, condition.Type)
+		changed := previous == nil || previous.Status != condition.Status
+		switch {
+		case condition.Type == wkmv1alpha1.ConditionOverridden:
+			if changed && condition.Status == v12.ConditionTrue {
+				r.Recorder.Event(state.obj, corev1.EventTypeWarning, webhook.EventReasonOverride, condition.Message)
+			} else if changed && previous != nil && previous.Status == v12.ConditionTrue {
+				r.Recorder.Event(state.obj, corev1.EventTypeNormal, EventReasonOverrideExpired, condition.Message)
+			}
+		case condition.Status == v12.ConditionFalse && changed:
+			r.Recorder.Event(state.obj, corev1.EventTypeWarning, EventReasonDrift, condition.Message)
+		case condition.Status == v12.ConditionTrue && previous != nil && previous.Status == v12.ConditionFalse:
+			r.Recorder.Eventf(state.obj, corev1.EventTypeNormal, EventReasonRestored, "%s恢复: %s", condition.Type, condition.Message)
+		}
+		meta.SetStatusCondition(&status.Status.Conditions, condition)
+	}
+	// 移除不再适用的condition, 如已删除的覆盖
+	for _, condition := range before.Conditions {
+		if !current[condition.Type] {
+			meta.RemoveStatusCondition(&status.Status.Conditions, condition.Type)
+		}
+	}
+	if equality.Semantic.DeepEqual(before, &status.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, status)
+}
+
+// StatusName 工作负载对应的DependencyStatus名称, 如deployment-ocm
+func StatusName(kind, name string) string {
+	return strings.ToLower(kind) + "-" + name
+}
+
+// SetupWithManager 监听Deployment、StatefulSet、DaemonSet和已注册的自定义工作负载, 需在注册自定义工作负载后调用
+func (r *ComplianceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := controller.New("compliance", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+	objs, err := watchedWorkloads(mgr)
+	if err != nil {
+		return err
+	}
+
+	// 忽略只有status变化的更新, 如副本数变化
+	changed := predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+	for _, obj := range objs {
+		if err = c.Watch(&source.Kind{Type: obj}, handler.EnqueueRequestsFromMapFunc(namespaceRequest), changed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 需要监听的工作负载类型: Deployment、StatefulSet、DaemonSet和集群中已安装的自定义工作负载
+func watchedWorkloads(mgr ctrl.Manager) ([]client.Object, error) {
+	objs := []client.Object{&appsv1.Deployment{}, &appsv1.StatefulSet{}, &appsv1.DaemonSet{}}
+	for _, kind := range registry.WorkloadKinds() {
+		gvk := kind.GroupVersionKind()
+		if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+			// 集群中未安装对应的CRD时跳过
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, err
+		}
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+func namespaceRequest(obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace()}}}
+}
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := wkmv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func getCondition(t *testing.T, c client.Client, name, conditionType string) *metav1.Condition {
+	t.Helper()
+	var status wkmv1alpha1.DependencyStatus
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, &status); err != nil {
+		t.Fatal(err)
+	}
+	condition := meta.FindStatusCondition(status.Status.Conditions, conditionType)
+	if condition == nil {
+		t.Fatalf("%s缺少condition %s", name, conditionType)
+	}
+	return condition
+}
+
+func TestComplianceReconciler_Reconcile(t *testing.T) {
+	scheme := newTestScheme(t)
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "2.3.0", nil)
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", map[string]string{"ocm": "^3.0.0"})
+	sources := map[string][]registry.ConstraintSource{"ocm": {{Container: "wmc", Constraint: "^3.0.0"}}}
+	registry.SetObjDependenceSources(wmc, sources)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ocm, wmc).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &ComplianceReconciler{Client: c, Scheme: scheme, Recorder: recorder}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default"}}
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var status wkmv1alpha1.DependencyStatus
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "deployment-wmc"}, &status); err != nil {
+		t.Fatal(err)
+	}
+	wantSources := map[string][]wkmv1alpha1.ConstraintSource{"ocm": {{Container: "wmc", Constraint: "^3.0.0"}}}
+	if got := status.Status.DependenceSources; !reflect.DeepEqual(got, wantSources) {
+		t.Errorf("DependenceSources = %v, want %v", got, wantSources)
+	}
+	tests := []struct {
+		name          string
+		conditionType string
+		want          metav1.ConditionStatus
+	}{
+		{name: "deployment-wmc", conditionType: wkmv1alpha1.ConditionForward, want: metav1.ConditionFalse},
+		{name: "deployment-wmc", conditionType: wkmv1alpha1.ConditionReverse, want: metav1.ConditionTrue},
+		{name: "deployment-ocm", conditionType: wkmv1alpha1.ConditionForward, want: metav1.ConditionTrue},
+		{name: "deployment-ocm", conditionType: wkmv1alpha1.ConditionReverse, want: metav1.ConditionFalse},
+	}
+	for _, tt := range tests {
+		if got := getCondition(t, c, tt.name, tt.conditionType); got.Status != tt.want {
+			t.Errorf("%s %s = %v, want %v", tt.name, tt.conditionType, got.Status, tt.want)
+		}
+	}
+	if got := len(recorder.Events); got != 2 {
+		t.Errorf("drift events = %d, want 2", got)
+	}
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; !strings.Contains(e, EventReasonDrift) {
+			t.Errorf("unexpected event %q", e)
+		}
+	}
+
+	// 再次检查结果不变时不重复产生事件
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if got := len(recorder.Events); got != 0 {
+		t.Errorf("repeated events = %d, want 0", got)
+	}
+
+	// ocm升级后恢复
+	var current appsv1.Deployment
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ocm), &current); err != nil {
+		t.Fatal(err)
+	}
+	registry.SetObjVersion(&current, "3.0.0", nil)
+	if err := c.Update(ctx, &current); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if got := getCondition(t, c, "deployment-wmc", wkmv1alpha1.ConditionForward); got.Status != metav1.ConditionTrue {
+		t.Errorf("deployment-wmc %s = %v, want True", wkmv1alpha1.ConditionForward, got.Status)
+	}
+	if got := len(recorder.Events); got != 2 {
+		t.Errorf("restored events = %d, want 2", got)
+	}
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; !strings.Contains(e, EventReasonRestored) {
+			t.Errorf("unexpected event %q", e)
+		}
+	}
+}
+
+func TestComplianceReconciler_Malformed(t *testing.T) {
+	scheme := newTestScheme(t)
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "2.3.0", nil)
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", map[string]string{"ocm": "2.x.y"})
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ocm, wmc).Build()
+	r := &ComplianceReconciler{Client: c, Scheme: scheme, Recorder: record.NewFakeRecorder(10)}
+
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default"}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	// 格式错误的约束只标记声明它的对象
+	if got := getCondition(t, c, "deployment-wmc", wkmv1alpha1.ConditionForward); got.Reason != wkmv1alpha1.ReasonMalformed {
+		t.Errorf("deployment-wmc %s reason = %v, want %v", wkmv1alpha1.ConditionForward, got.Reason, wkmv1alpha1.ReasonMalformed)
+	}
+	if got := getCondition(t, c, "deployment-ocm", wkmv1alpha1.ConditionReverse); got.Status != metav1.ConditionTrue {
+		t.Errorf("deployment-ocm %s = %v, want True", wkmv1alpha1.ConditionReverse, got.Status)
+	}
+}
+
+func TestComplianceReconciler_Capability(t *testing.T) {
+	scheme := newTestScheme(t)
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "2.3.0", nil)
+	registry.SetObjCapability(ocm, map[string]string{"ocm.api": "2.3.0"})
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", map[string]string{"cap_ocm.api": "^2.0"})
+	cms := testutil.NewDeployment("cms", "harbor:5000/wecloud/cms:4.0.0", "4.0.0", map[string]string{"cap_ocm.api": "required:^3.0"})
+	// 用户声明的能力依赖约束同样需要满足
+	mail := testutil.NewDeployment("mail", "harbor:5000/wecloud/mail:1.0.0", "1.0.0", nil)
+	mail.Annotations = map[string]string{registry.UserDependenceAnnotation("cap_ocm.api"): "^3.0"}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ocm, wmc, cms, mail).Build()
+	r := &ComplianceReconciler{Client: c, Scheme: scheme, Recorder: record.NewFakeRecorder(10)}
+
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default"}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var status wkmv1alpha1.DependencyStatus
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "deployment-wmc"}, &status); err != nil {
+		t.Fatal(err)
+	}
+	want := []wkmv1alpha1.ResolvedCapability{{Capability: "ocm.api", Constraint: "^2.0", Provider: "ocm", Version: "2.3.0"}}
+	if !reflect.DeepEqual(status.Status.ResolvedCapabilities, want) {
+		t.Errorf("deployment-wmc resolvedCapabilities = %v, want %v", status.Status.ResolvedCapabilities, want)
+	}
+	for _, name := range []string{"deployment-cms", "deployment-mail"} {
+		if got := getCondition(t, c, name, wkmv1alpha1.ConditionForward); got.Status != metav1.ConditionFalse || !strings.Contains(got.Message, "ocm(2.3.0)") {
+			t.Errorf("%s %s = %v %s, want False", name, wkmv1alpha1.ConditionForward, got.Status, got.Message)
+		}
+	}
+}
+
+func TestComplianceReconciler_DependenceEnv(t *testing.T) {
+	scheme := newTestScheme(t)
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.4.0", "2.4.0", nil)
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", map[string]string{"ocm": "^2.0.0"})
+	wmc.Annotations[webhook.K8sAnnotationInjectDependenceEnv] = "true"
+	wmc.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "WKM_DEP_OCM_VERSION", Value: "2.3.0"}}
+	cms := testutil.NewDeployment("cms", "harbor:5000/wecloud/cms:4.0.0", "4.0.0", map[string]string{"ocm": "^2.0.0"})
+	// 没有修改自定义工作负载的权限, 不更新
+	ccs := testutil.NewRollout("ccs", "harbor:5000/wecloud/ccs:1.2.0", "1.2.0", map[string]string{"ocm": "^2.0.0"})
+	ccs.SetAnnotations(map[string]string{webhook.K8sAnnotationInjectDependenceEnv: "true", "ocm" + registry.K8sAnnotationDependence: "^2.0.0"})
+	if err := unstructured.SetNestedSlice(ccs.Object, []interface{}{map[string]interface{}{
+		"name": "ccs", "image": "harbor:5000/wecloud/ccs:1.2.0",
+		"env": []interface{}{map[string]interface{}{"name": "WKM_DEP_OCM_VERSION", "value": "2.3.0"}},
+	}}, "spec", "template", "spec", "containers"); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ocm, wmc, cms, ccs).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &ComplianceReconciler{Client: c, Scheme: scheme, Recorder: recorder}
+
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default"}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	for name, want := range map[string][]corev1.EnvVar{
+		"wmc": {{Name: "WKM_DEP_OCM_VERSION", Value: "2.4.0"}},
+		// 未要求注入的工作负载不修改
+		"cms": nil,
+	} {
+		var got appsv1.Deployment
+		if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if env := got.Spec.Template.Spec.Containers[0].Env; !reflect.DeepEqual(env, want) {
+			t.Errorf("%s env = %v, want %v", name, env, want)
+		}
+	}
+	var got unstructured.Unstructured
+	got.SetGroupVersionKind(ccs.GroupVersionKind())
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "ccs"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if containers, _, _ := unstructured.NestedSlice(got.Object, "spec", "template", "spec", "containers"); !reflect.DeepEqual(containers, []interface{}{map[string]interface{}{
+		"name": "ccs", "image": "harbor:5000/wecloud/ccs:1.2.0",
+		"env": []interface{}{map[string]interface{}{"name": "WKM_DEP_OCM_VERSION", "value": "2.3.0"}},
+	}}) {
+		t.Errorf("ccs containers = %v, want unchanged", containers)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, webhook.EventReasonDependenceEnv) {
+			t.Errorf("event = %q, want %s", event, webhook.EventReasonDependenceEnv)
+		}
+	default:
+		t.Errorf("missing %s event", webhook.EventReasonDependenceEnv)
+	}
+}
+
+func TestOverrideCondition(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		wantNil       bool
+		wantStatus    metav1.ConditionStatus
+		wantReason    string
+		wantRemaining time.Duration
+	}{
+		{name: "none", wantNil: true},
+		{name: "active", annotations: map[string]string{
+			webhook.K8sAnnotationOverride:        "hotfix",
+			webhook.K8sAnnotationOverrideExpires: now.Add(time.Hour).Format(time.RFC3339),
+		}, wantStatus: metav1.ConditionTrue, wantReason: wkmv1alpha1.ReasonActive, wantRemaining: time.Hour},
+		{name: "expired", annotations: map[string]string{
+			webhook.K8sAnnotationOverride:        "hotfix",
+			webhook.K8sAnnotationOverrideExpires: now.Add(-time.Hour).Format(time.RFC3339),
+		}, wantStatus: metav1.ConditionFalse, wantReason: wkmv1alpha1.ReasonExpired},
+		{name: "invalid", annotations: map[string]string{
+			webhook.K8sAnnotationOverride:        "hotfix",
+			webhook.K8sAnnotationOverrideExpires: "tomorrow",
+		}, wantStatus: metav1.ConditionFalse, wantReason: wkmv1alpha1.ReasonInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", nil)
+			for k, v := range tt.annotations {
+				obj.Annotations[k] = v
+			}
+			got, remaining := overrideCondition(obj, now)
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("overrideCondition() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Status != tt.wantStatus || got.Reason != tt.wantReason {
+				t.Fatalf("overrideCondition() = %+v, want %v %v", got, tt.wantStatus, tt.wantReason)
+			}
+			// 过期时间精确到秒
+			if diff := remaining - tt.wantRemaining; diff > time.Second || diff < -time.Second {
+				t.Errorf("overrideCondition() remaining = %v, want %v", remaining, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestComplianceReconciler_Exempt(t *testing.T) {
+	scheme := newTestScheme(t)
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "2.3.0", map[string]string{"cms": "^9.0.0"})
+	ocm.Labels["dictator.io/exempt"] = "true"
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", map[string]string{"ocm": "^2.0.0"})
+	proxy := testutil.NewDeployment("kube-proxy", "harbor:5000/wecloud/kube-proxy:1.0.0", "1.0.0", map[string]string{"ocm": "^3.0.0"})
+	proxy.Namespace = "kube-system"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ocm, wmc, proxy).Build()
+	options := webhook.Options{Exemptions: webhook.ExemptionConfig{
+		ObjectSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"dictator.io/exempt": "true"}},
+	}}
+	r := &ComplianceReconciler{Client: c, Scheme: scheme, Recorder: record.NewFakeRecorder(10), Options: options}
+	ctx := context.Background()
+
+	for _, ns := range []string{"default", "kube-system"} {
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: ns}}); err != nil {
+			t.Fatalf("Reconcile(%s) error = %v", ns, err)
+		}
+	}
+	// 豁免的对象仍作为被依赖服务参与检查
+	if got := getCondition(t, c, "deployment-wmc", wkmv1alpha1.ConditionForward); got.Status != metav1.ConditionTrue {
+		t.Errorf("deployment-wmc %s = %v, want True", wkmv1alpha1.ConditionForward, got.Status)
+	}
+	var statuses wkmv1alpha1.DependencyStatusList
+	if err := c.List(ctx, &statuses); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses.Items {
+		if status.Namespace != "default" || status.Name != "deployment-wmc" {
+			t.Errorf("豁免的对象不应记录依赖状态, got %s/%s", status.Namespace, status.Name)
+		}
+	}
+}
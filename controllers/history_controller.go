@@ -0,0 +1,147 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+	"time"
+)
+
+//+kubebuilder:rbac:groups=wkm.welljoint.com,resources=versionhistories,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch
+
+// HistoryReconciler 工作负载的版本label变化并写入后, 在服务的版本历史中追加记录
+// 只根据已写入的对象记录, 准入阶段被拒绝的变更不会产生记录; 请求中为工作负载的命名空间和名称
+// 请求中不含工作负载的类型, 每种工作负载使用单独的控制器, 按类型直接获取请求的对象
+type HistoryReconciler struct {
+	client.Client
+	// 每个服务保留的版本历史条数
+	Limit int
+
+	// 当前控制器监听的工作负载类型的空对象, 由SetupWithManager设置
+	kind client.Object
+}
+
+func (r *HistoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	obj := r.kind.DeepCopyObject().(client.Object)
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		// 工作负载已删除时不记录
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	workload, ok := registry.GetWorkload(obj)
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	version := workload.Meta.GetLabels()[registry.K8sLabelVersion]
+	if version == "" {
+		return ctrl.Result{}, nil
+	}
+
+	var history wkmv1alpha1.VersionHistory
+	err := r.Get(ctx, req.NamespacedName, &history)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		history = wkmv1alpha1.VersionHistory{ObjectMeta: v12.ObjectMeta{Namespace: req.Namespace, Name: req.Name}}
+	}
+	// 以最后一条记录的版本为变更前的版本, 版本未变化时不重复记录
+	var oldVersion string
+	if n := len(history.Spec.Entries); n > 0 {
+		oldVersion = history.Spec.Entries[n-1].NewVersion
+	}
+	if version == oldVersion {
+		return ctrl.Result{}, nil
+	}
+
+	annotations := workload.Meta.GetAnnotations()
+	history.Spec.Entries = append(history.Spec.Entries, wkmv1alpha1.VersionHistoryEntry{
+		Time:        v12.NewTime(time.Now()),
+		Kind:        workload.GVK.Kind,
+		OldVersion:  oldVersion,
+		NewVersion:  version,
+		Dependences: registry.GetObjDependence(workload.Meta),
+		User:        annotations[registry.K8sAnnotationChangedBy],
+		Comment:     annotations[registry.K8sAnnotationChangeCause],
+		Ssid:        annotations[registry.K8sAnnotationSsid],
+	})
+	if n := len(history.Spec.Entries) - r.Limit; n > 0 {
+		history.Spec.Entries = history.Spec.Entries[n:]
+	}
+	if history.ResourceVersion == "" {
+		err = r.Create(ctx, &history)
+	} else {
+		err = r.Update(ctx, &history)
+	}
+	if err != nil {
+		// 冲突时重新入队, 以最新的版本历史重新比较
+		logger.Info("记录版本历史失败", "name", req.Name, "version", version, "err", err)
+		return ctrl.Result{}, err
+	}
+	logger.Info("记录版本历史", "name", req.Name, "oldVersion", oldVersion, "version", version)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager 为Deployment、StatefulSet、DaemonSet和已注册的自定义工作负载各创建一个控制器, 需在注册自定义工作负载后调用
+// 集群中未安装VersionHistory CRD时记录错误并跳过, 不影响其他控制器启动
+func (r *HistoryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	gvk := wkmv1alpha1.GroupVersion.WithKind("VersionHistory")
+	if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		if meta.IsNoMatchError(err) {
+			mgr.GetLogger().Error(err, "集群中未安装VersionHistory CRD, 不记录版本历史; 请先安装CRD或使用--history-limit=0关闭")
+			return nil
+		}
+		return err
+	}
+	objs, err := watchedWorkloads(mgr)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		kind, err := apiutil.GVKForObject(obj, mgr.GetScheme())
+		if err != nil {
+			return err
+		}
+		reconciler := *r
+		reconciler.kind = obj
+		c, err := controller.New("history-"+strings.ToLower(kind.Kind), mgr, controller.Options{Reconciler: &reconciler})
+		if err != nil {
+			return err
+		}
+		// 版本记录在label中, 只关心label的变化
+		if err = c.Watch(&source.Kind{Type: obj}, &handler.EnqueueRequestForObject{}, predicate.LabelChangedPredicate{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func TestHistoryReconciler_Reconcile(t *testing.T) {
+	scheme := newTestScheme(t)
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.0", "1.8.0", map[string]string{"ocm": "^2.0.0"})
+	wmc.Annotations[registry.K8sAnnotationChangedBy] = "admin"
+	wmc.Annotations[registry.K8sAnnotationChangeCause] = "上线"
+	// 同名的StatefulSet不影响Deployment控制器
+	ocm := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ocm"}}
+	registry.SetObjVersion(ocm, "2.3.0", nil)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(wmc, ocm).Build()
+	r := &HistoryReconciler{Client: c, Limit: 2, kind: &appsv1.Deployment{}}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "wmc"}}
+	ctx := context.Background()
+
+	reconcile := func() []wkmv1alpha1.VersionHistoryEntry {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		var history wkmv1alpha1.VersionHistory
+		if err := c.Get(ctx, req.NamespacedName, &history); err != nil {
+			t.Fatal(err)
+		}
+		return history.Spec.Entries
+	}
+	update := func(version, user, comment string) {
+		t.Helper()
+		if err := c.Get(ctx, req.NamespacedName, wmc); err != nil {
+			t.Fatal(err)
+		}
+		registry.SetObjVersion(wmc, version, map[string]string{"ocm": "^2.0.0"})
+		wmc.Annotations[registry.K8sAnnotationChangedBy] = user
+		wmc.Annotations[registry.K8sAnnotationChangeCause] = comment
+		if err := c.Update(ctx, wmc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := reconcile()
+	if len(entries) != 1 {
+		t.Fatalf("Reconcile() entries = %+v, want 1", entries)
+	}
+	if e := entries[0]; e.OldVersion != "" || e.NewVersion != "1.8.0" || e.User != "admin" || e.Comment != "上线" ||
+		e.Kind != "Deployment" || e.Dependences["ocm"] != "^2.0.0" {
+		t.Errorf("Reconcile() entries[0] = %+v", e)
+	}
+
+	// mutate webhook已写入新版本和用户, 但更新在之后的准入阶段被拒绝, 对象未写入
+	rejected := wmc.DeepCopy()
+	registry.SetObjVersion(rejected, "1.9.0", nil)
+	rejected.Annotations[registry.K8sAnnotationChangedBy] = "deployer"
+	if entries = reconcile(); len(entries) != 1 {
+		t.Errorf("Reconcile() after rejected update entries = %+v, want 1", entries)
+	}
+
+	update("1.8.1", "deployer", "修复登录")
+	entries = reconcile()
+	// 重复调谐不重复记录
+	if again := reconcile(); len(again) != len(entries) {
+		t.Errorf("Reconcile() again entries = %+v, want %d", again, len(entries))
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Reconcile() entries = %+v, want 2", entries)
+	}
+	if e := entries[1]; e.OldVersion != "1.8.0" || e.NewVersion != "1.8.1" || e.User != "deployer" || e.Comment != "修复登录" {
+		t.Errorf("Reconcile() entries[1] = %+v", e)
+	}
+
+	// 超出条数限制时丢弃最早的记录
+	update("1.8.2", "deployer", "")
+	entries = reconcile()
+	if len(entries) != 2 || entries[0].NewVersion != "1.8.1" || entries[1].OldVersion != "1.8.1" || entries[1].NewVersion != "1.8.2" {
+		t.Errorf("Reconcile() entries = %+v", entries)
+	}
+
+	// 已删除或其他类型的工作负载不记录
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "ocm"}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "ocm"}, &wkmv1alpha1.VersionHistory{}); err == nil {
+		t.Errorf("Reconcile() want no history for missing workload")
+	}
+}
+
+func TestHistoryReconciler_CustomKind(t *testing.T) {
+	rollout := testutil.NewRollout("ocm", "harbor:5000/wecloud/ocm:2.3.0", "2.3.0", nil)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(rollout).Build()
+	kind := &unstructured.Unstructured{}
+	kind.SetGroupVersionKind(rollout.GroupVersionKind())
+	r := &HistoryReconciler{Client: c, Limit: 2, kind: kind}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "ocm"}}
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var history wkmv1alpha1.VersionHistory
+	if err := c.Get(context.Background(), req.NamespacedName, &history); err != nil {
+		t.Fatal(err)
+	}
+	if kind := history.Spec.Entries[0].Kind; kind != "Rollout" {
+		t.Errorf("Reconcile() kind = %q, want Rollout", kind)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	"io"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 以表格形式打印命名空间下服务的版本历史
+func listHistory(ctx context.Context, c client.Client, w io.Writer, namespace, svc string) error {
+	records, err := webhook.ListHistory(ctx, c, namespace, svc)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSERVICE\tKIND\tOLD\tNEW\tUSER\tSSID\tDEPENDENCES\tCOMMENT")
+	for _, r := range records {
+		deps := make([]string, 0, len(r.Dependences))
+		for name, constraint := range r.Dependences {
+			deps = append(deps, name+"="+constraint)
+		}
+		sort.Strings(deps)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.Format(time.RFC3339), r.Service, r.Kind,
+			orNone(r.OldVersion), r.NewVersion, orNone(r.User), orNone(r.Ssid), orNone(strings.Join(deps, ",")), r.Comment)
+	}
+	return tw.Flush()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+// Package testutil 各包测试共用的内存镜像仓库和工作负载构造函数
+package testutil
+
+import (
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Namespace 测试对象所在的命名空间
+const Namespace = "default"
+
+// NewRegistry 启动内存镜像仓库, 返回仓库地址
+func NewRegistry(t *testing.T) string {
+	t.Helper()
+	s := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// PushImage 推送带有指定label的测试镜像, 返回镜像地址
+func PushImage(t *testing.T, host, repo string, labels map[string]string) string {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.Labels = labels
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatal(err)
+	}
+	image := host + "/" + repo
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(ref, img); err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+// NewDeployment 构造测试命名空间下的Deployment
+// version不为空时视为已经过mutate webhook, 设置版本label和依赖约束annotation
+func NewDeployment(name, image, version string, deps map[string]string) *appsv1.Deployment {
+	labels := map[string]string{"app": name}
+	obj := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: Namespace, Name: name, UID: types.UID(name + "-uid")},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: name, Image: image}},
+				},
+			},
+		},
+	}
+	if version != "" {
+		registry.SetObjVersion(obj, version, deps)
+	}
+	return obj
+}
+
+// NewRollout 构造测试命名空间下已经过mutate webhook的Argo Rollout, 并注册为自定义工作负载
+func NewRollout(name, image, version string, deps map[string]string) *unstructured.Unstructured {
+	registry.RegisterWorkloadKind(registry.WorkloadKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"})
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata":   map[string]interface{}{"name": name, "namespace": Namespace, "uid": name + "-uid"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": name, "image": image},
+					},
+				},
+			},
+		},
+	}}
+	registry.SetObjVersion(obj, version, deps)
+	return obj
+}
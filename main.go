@@ -18,8 +18,14 @@ package main
 
 import (
 	"flag"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/controllers"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/server"
 	"gitlab.wellcloud.cc/cloud/dictator/webhook"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -29,6 +35,7 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	//+kubebuilder:scaffold:imports
@@ -41,6 +48,7 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(wkmv1alpha1.AddToScheme(scheme))
 
 	//+kubebuilder:scaffold:scheme
 }
@@ -49,11 +57,87 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var defaultPlatform string
+	var workloadConfig string
+	var enablePodWebhook bool
+	var checkLiveVersions bool
+	var readiness webhook.ReadinessRequirement
+	var enableCompliance bool
+	var complianceInterval time.Duration
+	var backfillOnce bool
+	var backfillInterval time.Duration
+	var exemptionConfig string
+	var overrideMaxDuration time.Duration
+	var upgradePolicyConfig string
+	var enableAPI bool
+	var historyLimit int
+	var listHistoryNamespace string
+	var listHistoryService string
+	var wait webhook.WaitConfig
+	var waitFor string
+	var waitCAFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&defaultPlatform, "default-platform", "",
+		"The platform (e.g. linux/amd64) used to read labels from multi-arch images whose workload has no node selector. "+
+			"When empty, all platforms of the image are read and must declare the same dependencies.")
+	flag.StringVar(&workloadConfig, "workload-config", "",
+		"The file listing custom workload kinds (e.g. Argo Rollouts, OpenKruise CloneSets) and their pod template paths.")
+	flag.BoolVar(&enablePodWebhook, "enable-pod-webhook", false,
+		"Enable forward dependence checks for Pods that are not managed by a ReplicaSet, StatefulSet, DaemonSet or custom workload.")
+	flag.BoolVar(&checkLiveVersions, "check-live-versions", false,
+		"Check dependencies against every version still running in active ReplicaSets and Pods, not only the declared one.")
+	flag.IntVar(&readiness.MinReady, "min-ready-replicas", 0,
+		"Require at least this many ready replicas of a dependency to run a compatible version. 0 disables the check.")
+	flag.IntVar(&readiness.MinReadyPercent, "min-ready-percent", 0,
+		"Require at least this percentage of a dependency's desired replicas to be ready and run a compatible version. 0 disables the check.")
+	flag.BoolVar(&enableCompliance, "enable-compliance-controller", false,
+		"Enable the controller that keeps checking the dependencies of all workloads and reports the results in DependencyStatus resources. "+
+			"Requires the DependencyStatus CRD to be installed.")
+	flag.DurationVar(&complianceInterval, "compliance-interval", 10*time.Minute,
+		"How often the compliance controller re-checks a namespace to pick up dependency changes in images. 0 checks only when workloads change.")
+	flag.BoolVar(&backfillOnce, "backfill-once", false,
+		"Add the version label and dependence annotations to all existing workloads, then exit without starting the manager. "+
+			"Only metadata is patched, so no rollouts are triggered.")
+	flag.DurationVar(&backfillInterval, "backfill-interval", 0,
+		"How often the manager adds the version label and dependence annotations to existing workloads. 0 disables periodic backfill.")
+	flag.StringVar(&exemptionConfig, "exemption-config", "",
+		"The file listing namespaces, namespace and object label selectors, users and groups exempt from dependence checks. "+
+			"System namespaces and the namespace in POD_NAMESPACE are always exempt.")
+	flag.DurationVar(&overrideMaxDuration, "override-max-duration", webhook.DefaultOverrideMaxDuration,
+		"The longest validity of the "+webhook.K8sAnnotationOverride+" annotation. Overrides expiring later are rejected.")
+	flag.StringVar(&upgradePolicyConfig, "upgrade-policy-config", "",
+		"The file with per-namespace and per-service upgrade policies that forbid downgrades, limit major version jumps "+
+			"or require waypoint versions.")
+	flag.BoolVar(&enableAPI, "enable-api", false,
+		"Serve the HTTP API (what-if checks, checked image updates and version history) on the webhook server. Requests are authenticated with Kubernetes bearer tokens.")
+	flag.IntVar(&historyLimit, "history-limit", 50,
+		"The number of version changes kept in each service's VersionHistory. 0 disables recording.")
+	flag.StringVar(&listHistoryNamespace, "list-history", "",
+		"Print the version history of the given namespace and exit.")
+	flag.StringVar(&listHistoryService, "history-service", "",
+		"Limit --list-history to one service.")
+	flag.StringVar(&wait.Image, "wait-image", "",
+		"The dictator image injected as an init container into workloads annotated with "+webhook.K8sAnnotationWaitForDependencies+
+			"=true. The init container blocks until the dependencies have ready replicas at a compatible version. "+
+			"Requires --wait-endpoint and --enable-api.")
+	flag.StringVar(&wait.Endpoint, "wait-endpoint", "",
+		"The URL of the dictator API queried by the injected init container, e.g. https://dictator-webhook-service.dictator-system.svc.")
+	flag.StringVar(&wait.CAConfigMap, "wait-ca-configmap", "",
+		"The ConfigMap holding the CA that verifies the dictator API certificate under the "+webhook.WaitCAKey+" key. "+
+			"It must exist in each workload namespace and is mounted into the injected init container. When empty, system roots are used.")
+	flag.StringVar(&waitCAFile, "wait-ca-file", "",
+		"Run as the injected init container: the CA file that verifies the dictator API certificate. Set by the injected init container.")
+	flag.DurationVar(&wait.Timeout, "wait-timeout", 5*time.Minute,
+		"How long the injected init container waits for the dependencies before failing. "+
+			"Workloads can override it with the "+webhook.K8sAnnotationWaitTimeout+" annotation.")
+	flag.DurationVar(&wait.Interval, "wait-interval", 5*time.Second,
+		"How often the injected init container queries the dictator API.")
+	flag.StringVar(&waitFor, "wait-for-dependencies", "",
+		"Run as the injected init container: wait until the dependencies of the given service are ready, then exit.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -61,6 +145,100 @@ func main() {
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	webhookOptions := webhook.Options{
+		CheckLiveVersions:   checkLiveVersions,
+		Readiness:           readiness,
+		Wait:                wait,
+		OverrideMaxDuration: overrideMaxDuration,
+	}
+	if wait.Enabled() && !enableAPI {
+		setupLog.Error(nil, "--wait-image requires --enable-api to serve the readiness endpoint")
+		os.Exit(1)
+	}
+
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		webhookOptions.SystemNamespaces = append(webhookOptions.SystemNamespaces, ns)
+		// API以dictator自身的身份提交变更, webhook据此识别代为提交的请求
+		if sa := os.Getenv("SERVICE_ACCOUNT_NAME"); sa != "" {
+			webhookOptions.ServiceAccount = "system:serviceaccount:" + ns + ":" + sa
+		}
+	}
+	if exemptionConfig != "" {
+		exemptions, err := webhook.LoadExemptionConfig(exemptionConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load exemption config", "path", exemptionConfig)
+			os.Exit(1)
+		}
+		webhookOptions.Exemptions = exemptions
+	}
+
+	if upgradePolicyConfig != "" {
+		policies, err := webhook.LoadUpgradePolicyConfig(upgradePolicyConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load upgrade policy config", "path", upgradePolicyConfig)
+			os.Exit(1)
+		}
+		webhookOptions.UpgradePolicies = policies
+	}
+
+	if defaultPlatform != "" {
+		platform, err := v1.ParsePlatform(defaultPlatform)
+		if err != nil {
+			setupLog.Error(err, "invalid default platform", "platform", defaultPlatform)
+			os.Exit(1)
+		}
+		webhookOptions.DefaultPlatform = platform
+	}
+
+	var workloadKinds []registry.WorkloadKind
+	if workloadConfig != "" {
+		kinds, err := registry.LoadWorkloadKinds(workloadConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load workload config", "path", workloadConfig)
+			os.Exit(1)
+		}
+		for _, kind := range kinds {
+			registry.RegisterWorkloadKind(kind)
+		}
+		workloadKinds = kinds
+	}
+
+	if waitFor != "" {
+		if err := waitForDependencies(ctrl.SetupSignalHandler(), ctrl.Log.WithName("wait"), wait.Endpoint, waitFor,
+			waitCAFile, wait.Timeout, wait.Interval); err != nil {
+			setupLog.Error(err, "dependencies are not ready", "service", waitFor)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if listHistoryNamespace != "" {
+		c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			setupLog.Error(err, "unable to create client")
+			os.Exit(1)
+		}
+		if err = listHistory(ctrl.SetupSignalHandler(), c, os.Stdout, listHistoryNamespace, listHistoryService); err != nil {
+			setupLog.Error(err, "unable to list history", "namespace", listHistoryNamespace)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if backfillOnce {
+		c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			setupLog.Error(err, "unable to create client")
+			os.Exit(1)
+		}
+		patched, err := controllers.Backfill(ctrl.SetupSignalHandler(), c, ctrl.Log.WithName("backfill"), webhookOptions, "")
+		if err != nil {
+			setupLog.Error(err, "problem running backfill", "patched", patched)
+			os.Exit(1)
+		}
+		setupLog.Info("backfill finished", "patched", patched)
+		return
+	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
@@ -87,18 +265,69 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = webhook.SetupDeploymentWebhookWithManager(mgr); err != nil {
+	webhookOptions.Recorder = mgr.GetEventRecorderFor("dictator")
+	if err = webhook.SetupDeploymentWebhookWithManager(mgr, webhookOptions); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "Deployment")
 		os.Exit(1)
 	}
-	if err = webhook.SetupStatefulSetWebhookWithManager(mgr); err != nil {
+	if err = webhook.SetupStatefulSetWebhookWithManager(mgr, webhookOptions); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "StatefulSet")
 		os.Exit(1)
 	}
-	if err = webhook.SetupDaemonSetWebhookWithManager(mgr); err != nil {
+	if err = webhook.SetupDaemonSetWebhookWithManager(mgr, webhookOptions); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "DaemonSet")
 		os.Exit(1)
 	}
+	for _, kind := range workloadKinds {
+		if err = webhook.SetupWorkloadWebhookWithManager(mgr, kind, webhookOptions); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", kind.Kind)
+			os.Exit(1)
+		}
+	}
+	if enablePodWebhook {
+		if err = webhook.SetupPodWebhookWithManager(mgr, webhookOptions); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Pod")
+			os.Exit(1)
+		}
+	}
+	if enableCompliance {
+		if err = (&controllers.ComplianceReconciler{
+			Client:   mgr.GetClient(),
+			Scheme:   mgr.GetScheme(),
+			Recorder: mgr.GetEventRecorderFor("dictator"),
+			Options:  webhookOptions,
+			Interval: complianceInterval,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Compliance")
+			os.Exit(1)
+		}
+	}
+	if historyLimit > 0 {
+		if err = (&controllers.HistoryReconciler{
+			Client: mgr.GetClient(),
+			Limit:  historyLimit,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "History")
+			os.Exit(1)
+		}
+	}
+	if backfillInterval > 0 {
+		if err = mgr.Add(&controllers.Backfiller{
+			Client:   mgr.GetClient(),
+			Logger:   ctrl.Log.WithName("backfill"),
+			Options:  webhookOptions,
+			Interval: backfillInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to add backfill")
+			os.Exit(1)
+		}
+	}
+	if enableAPI {
+		if webhookOptions.ServiceAccount == "" {
+			setupLog.Info("POD_NAMESPACE or SERVICE_ACCOUNT_NAME is not set, changes applied through the API are checked as dictator instead of the caller")
+		}
+		server.SetupServerWithManager(mgr, webhookOptions)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
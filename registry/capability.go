@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"sort"
+	"strings"
+)
+
+// CapabilityPrefix 依赖约束中能力的前缀
+// 镜像以 ver_cap_ocm.api=^2.0 声明对能力ocm.api的依赖约束, 由提供该能力的任意服务满足即可
+// 服务名不能包含"_", 因此不会与服务的依赖约束混淆
+const CapabilityPrefix = "cap_"
+
+// IsCapability 判断依赖约束的键是否为能力, 返回能力名称
+func IsCapability(key string) (string, bool) {
+	if len(key) <= len(CapabilityPrefix) || !strings.HasPrefix(key, CapabilityPrefix) {
+		return "", false
+	}
+	return key[len(CapabilityPrefix):], true
+}
+
+// 依赖约束记录在对象上的annotation
+func dependenceAnnotation(key string) string {
+	if capability, ok := IsCapability(key); ok {
+		return capability + K8sAnnotationCapabilityDependence
+	}
+	return key + K8sAnnotationDependence
+}
+
+// UserDependenceAnnotation 用户声明的依赖约束记录在对象上的annotation, key为服务名或带CapabilityPrefix前缀的能力名
+func UserDependenceAnnotation(key string) string {
+	if capability, ok := IsCapability(key); ok {
+		return capability + K8sAnnotationUserCapabilityDependence
+	}
+	return key + K8sAnnotationUserDependence
+}
+
+// 校验能力名称和版本, 版本补全为x.y.z, 如 2.3 -> 2.3.0
+func parseCapabilityVersion(capability, version string) (string, error) {
+	if errs := validation.IsDNS1123Subdomain(capability); len(errs) > 0 {
+		return "", fmt.Errorf("能力名称%s不合法: %s", capability, strings.Join(errs, ", "))
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("能力%s的版本(%s)不合法: %w", capability, version, err)
+	}
+	return v.String(), nil
+}
+
+// SetObjCapability 设置对象提供的能力, 不再提供的能力会被移除
+func SetObjCapability(obj v12.Object, capabilities map[string]string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for capability := range GetObjCapability(obj) {
+		if _, ok := capabilities[capability]; !ok {
+			delete(annotations, capability+K8sAnnotationCapability)
+		}
+	}
+	for k, v := range capabilities {
+		annotations[k+K8sAnnotationCapability] = v
+	}
+	obj.SetAnnotations(annotations)
+}
+
+// GetObjCapability 获取对象上记录的提供的能力
+func GetObjCapability(obj v12.Object) map[string]string {
+	capabilities := make(map[string]string)
+	for k, v := range obj.GetAnnotations() {
+		if capability := strings.TrimSuffix(k, K8sAnnotationCapability); capability != k && capability != "" {
+			capabilities[capability] = v
+		}
+	}
+	return capabilities
+}
+
+// CapabilityResolution 能力依赖约束的解析结果
+type CapabilityResolution struct {
+	Capability string
+	Constraint string
+	// 满足约束的服务及其提供的能力版本
+	Provider string
+	Version  string
+}
+
+func (r CapabilityResolution) String() string {
+	return fmt.Sprintf("%s(%s)由%s(%s)提供", r.Capability, r.Constraint, r.Provider, r.Version)
+}
+
+// ResolveCapabilities 在objs中为deps里的能力依赖约束查找提供者, 返回每个能力由哪个服务满足
+// 能力存在多个提供者时, 任意一个满足约束即可, 按服务名依次选择; 没有提供者时只检查required修饰符
+// deps可传入多组约束(如镜像和用户声明的依赖约束), 同一能力在各组中的约束都需要满足
+func ResolveCapabilities(objs map[string]v12.Object, deps ...map[string]string) ([]CapabilityResolution, error) {
+	klog.V(4).Infof("能力检查: %v\n", deps)
+	var results []CapabilityResolution
+	for _, d := range deps {
+		for _, key := range sortedCapabilityKeys(d) {
+			capability, _ := IsCapability(key)
+			resolution, err := resolveCapability(objs, capability, d[key])
+			if err != nil {
+				return nil, fmt.Errorf("能力检查失败，%w", err)
+			}
+			if resolution != nil {
+				results = append(results, *resolution)
+			}
+		}
+	}
+	return results, nil
+}
+
+func resolveCapability(objs map[string]v12.Object, capability, constraint string) (*CapabilityResolution, error) {
+	r, err := ParseRequirement(constraint)
+	if err != nil {
+		return nil, err
+	}
+
+	var rejected []string
+	for _, svc := range sortedObjNames(objs) {
+		version := GetObjCapability(objs[svc])[capability]
+		if version == "" {
+			continue
+		}
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s(%s)", svc, version))
+			continue
+		}
+		if r.Conflict(v) != "" || !r.Matches(v) {
+			rejected = append(rejected, fmt.Sprintf("%s(%s)", svc, version))
+			continue
+		}
+		return &CapabilityResolution{Capability: capability, Constraint: constraint, Provider: svc, Version: version}, nil
+	}
+
+	if len(rejected) > 0 {
+		return nil, fmt.Errorf("能力%s的提供者均不符合依赖约束(%s)：%s", capability, constraint, strings.Join(rejected, "，"))
+	}
+	if r.Required {
+		return nil, fmt.Errorf("能力%s没有提供者，不满足依赖约束(%s)", capability, constraint)
+	}
+	klog.V(4).Infof("能力没有提供者: %s\n", capability)
+	return nil, nil
+}
+
+// CheckReverseCapability 反向能力检查
+// objs中svc为变更后的对象, previous为svc变更前提供的能力, 检查依赖svc变更前后所提供能力的其他对象是否仍能找到满足约束的提供者
+func CheckReverseCapability(objs map[string]v12.Object, svc string, previous map[string]string) error {
+	affected := make(map[string]bool, len(previous))
+	for capability := range previous {
+		affected[capability] = true
+	}
+	if obj := objs[svc]; obj != nil {
+		for capability, version := range GetObjCapability(obj) {
+			if previous[capability] != version {
+				affected[capability] = true
+			} else {
+				// 版本未变化的能力不影响其他对象
+				delete(affected, capability)
+			}
+		}
+	}
+	klog.V(4).Infof("反向能力检查: %s %v\n", svc, affected)
+	if len(affected) == 0 {
+		return nil
+	}
+
+	for _, name := range sortedObjNames(objs) {
+		if name == svc {
+			continue
+		}
+		for _, deps := range []map[string]string{GetObjDependence(objs[name]), GetObjUserDependence(objs[name])} {
+			for _, key := range sortedCapabilityKeys(deps) {
+				capability, _ := IsCapability(key)
+				if !affected[capability] {
+					continue
+				}
+				// 格式错误的约束由FindMalformedConstraints报告, 此处跳过
+				if _, err := ParseRequirement(deps[key]); err != nil {
+					continue
+				}
+				if _, err := resolveCapability(objs, capability, deps[key]); err != nil {
+					return fmt.Errorf("反向能力检查失败，%s变更后%s依赖的%w", svc, name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sortedObjNames(objs map[string]v12.Object) []string {
+	names := make([]string, 0, len(objs))
+	for name := range objs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 按服务名排序的能力依赖约束的键
+func sortedCapabilityKeys(deps map[string]string) []string {
+	keys := make([]string, 0, len(deps))
+	for k := range deps {
+		if _, ok := IsCapability(k); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,178 @@
+package registry
+
+import (
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetImageMetadataForPlatform(t *testing.T) {
+	host := newTestRegistry(t)
+	push := func(tag string, labels map[string]string) string {
+		image := host + "/wecloud/ocm:" + tag
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = remote.Write(ref, newTestImage(t, labels)); err != nil {
+			t.Fatal(err)
+		}
+		return image
+	}
+
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		wantDeps  map[string]string
+		wantCaps  map[string]string
+		wantError bool
+	}{
+		{
+			name:     "provide and require",
+			labels:   map[string]string{"cap_ocm.api": "2.3", "ver_cap_auth.oidc": "^1.0", "ver_cms": "^4.0.0"},
+			wantDeps: map[string]string{"cap_auth.oidc": "^1.0", "cms": "^4.0.0"},
+			wantCaps: map[string]string{"ocm.api": "2.3.0"},
+		},
+		{name: "invalid version", labels: map[string]string{"cap_ocm.api": "latest"}, wantError: true},
+		{name: "invalid name", labels: map[string]string{"cap_OCM_API": "2.3"}, wantError: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps, caps, err := GetImageMetadataForPlatform(push(strings.Repeat("v", i+1), tt.labels), nil)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("GetImageMetadataForPlatform() error = %v, wantErr %v", err, tt.wantError)
+			}
+			if tt.wantError {
+				return
+			}
+			if !reflect.DeepEqual(deps, tt.wantDeps) {
+				t.Errorf("GetImageMetadataForPlatform() deps = %v, want %v", deps, tt.wantDeps)
+			}
+			if !reflect.DeepEqual(caps, tt.wantCaps) {
+				t.Errorf("GetImageMetadataForPlatform() caps = %v, want %v", caps, tt.wantCaps)
+			}
+		})
+	}
+}
+
+func TestSetObjVersion_Capability(t *testing.T) {
+	obj := &v12.ObjectMeta{Name: "wmc"}
+	SetObjVersion(obj, "1.0.0", map[string]string{"ocm": "^2.0.0", "cap_ocm.api": "^2.0"})
+	SetObjCapability(obj, map[string]string{"wmc.ui": "1.0.0"})
+
+	want := map[string]string{
+		"ocm" + K8sAnnotationDependence:               "^2.0.0",
+		"ocm.api" + K8sAnnotationCapabilityDependence: "^2.0",
+		"wmc.ui" + K8sAnnotationCapability:            "1.0.0",
+	}
+	if !reflect.DeepEqual(obj.Annotations, want) {
+		t.Errorf("annotations = %v, want %v", obj.Annotations, want)
+	}
+	if got := GetObjDependence(obj); !reflect.DeepEqual(got, map[string]string{"ocm": "^2.0.0", "cap_ocm.api": "^2.0"}) {
+		t.Errorf("GetObjDependence() = %v", got)
+	}
+
+	SetObjVersion(obj, "1.0.1", map[string]string{"ocm": "^2.0.0"})
+	SetObjCapability(obj, nil)
+	if _, ok := obj.Annotations["ocm.api"+K8sAnnotationCapabilityDependence]; ok {
+		t.Errorf("capability dependence not removed: %v", obj.Annotations)
+	}
+	if got := GetObjCapability(obj); len(got) != 0 {
+		t.Errorf("GetObjCapability() = %v, want empty", got)
+	}
+}
+
+func newCapabilityObj(name string, capabilities, deps map[string]string) v12.Object {
+	obj := &v12.ObjectMeta{Name: name}
+	SetObjVersion(obj, "1.0.0", deps)
+	SetObjCapability(obj, capabilities)
+	return obj
+}
+
+func TestResolveCapabilities(t *testing.T) {
+	objs := map[string]v12.Object{
+		"ocm":     newCapabilityObj("ocm", map[string]string{"ocm.api": "2.3.0"}, nil),
+		"ocm-lts": newCapabilityObj("ocm-lts", map[string]string{"ocm.api": "1.9.0"}, nil),
+		"auth":    newCapabilityObj("auth", map[string]string{"auth.oidc": "1.2.0"}, nil),
+	}
+	tests := []struct {
+		name    string
+		deps    map[string]string
+		want    []CapabilityResolution
+		wantErr string
+	}{
+		{
+			name: "resolved by matching provider",
+			deps: map[string]string{"cap_ocm.api": "^2.0", "cap_auth.oidc": "~1.2", "cms": "^4.0.0"},
+			want: []CapabilityResolution{
+				{Capability: "auth.oidc", Constraint: "~1.2", Provider: "auth", Version: "1.2.0"},
+				{Capability: "ocm.api", Constraint: "^2.0", Provider: "ocm", Version: "2.3.0"},
+			},
+		},
+		{
+			name: "first provider by name",
+			deps: map[string]string{"cap_ocm.api": ">=1.0"},
+			want: []CapabilityResolution{{Capability: "ocm.api", Constraint: ">=1.0", Provider: "ocm", Version: "2.3.0"}},
+		},
+		{name: "conflicts skip provider", deps: map[string]string{"cap_ocm.api": ">=1.0; conflicts:2.3.0"},
+			want: []CapabilityResolution{{Capability: "ocm.api", Constraint: ">=1.0; conflicts:2.3.0", Provider: "ocm-lts", Version: "1.9.0"}}},
+		{name: "no provider optional", deps: map[string]string{"cap_mail.smtp": "^1.0"}},
+		{name: "no provider required", deps: map[string]string{"cap_mail.smtp": "required:^1.0"}, wantErr: "没有提供者"},
+		{name: "no matching provider", deps: map[string]string{"cap_ocm.api": "^3.0"}, wantErr: "ocm(2.3.0)，ocm-lts(1.9.0)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveCapabilities(objs, tt.deps)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveCapabilities() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveCapabilities() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveCapabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckReverseCapability(t *testing.T) {
+	wmc := newCapabilityObj("wmc", nil, map[string]string{"cap_ocm.api": "required:^2.0"})
+	tests := []struct {
+		name     string
+		ocm      map[string]string
+		previous map[string]string
+		others   map[string]v12.Object
+		wantErr  bool
+	}{
+		{name: "compatible upgrade", ocm: map[string]string{"ocm.api": "2.4.0"}, previous: map[string]string{"ocm.api": "2.3.0"}},
+		{name: "incompatible upgrade", ocm: map[string]string{"ocm.api": "3.0.0"}, previous: map[string]string{"ocm.api": "2.3.0"}, wantErr: true},
+		{name: "capability removed", ocm: nil, previous: map[string]string{"ocm.api": "2.3.0"}, wantErr: true},
+		{
+			name: "another provider remains", ocm: nil, previous: map[string]string{"ocm.api": "2.3.0"},
+			others: map[string]v12.Object{"ocm-next": newCapabilityObj("ocm-next", map[string]string{"ocm.api": "2.5.0"}, nil)},
+		},
+		{
+			name: "unrelated capability", ocm: map[string]string{"ocm.api": "2.3.0", "ocm.admin": "9.0.0"}, previous: map[string]string{"ocm.api": "2.3.0"},
+			others: map[string]v12.Object{"broken": newCapabilityObj("broken", nil, map[string]string{"cap_mail.smtp": "required"})},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := map[string]v12.Object{"wmc": wmc, "ocm": newCapabilityObj("ocm", tt.ocm, nil)}
+			for k, v := range tt.others {
+				objs[k] = v
+			}
+			err := CheckReverseCapability(objs, "ocm", tt.previous)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckReverseCapability() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"sort"
+	"strings"
+)
+
+// ConstraintSource 依赖约束的来源
+type ConstraintSource struct {
+	Container  string `json:"container"`  // 声明约束的容器
+	Constraint string `json:"constraint"` // 容器镜像声明的原始约束
+}
+
+func (s ConstraintSource) String() string {
+	return fmt.Sprintf("%s(%s)", s.Container, s.Constraint)
+}
+
+// Dependence 对某个服务的依赖约束, 由一个或多个容器声明的约束取交集得到
+type Dependence struct {
+	Service string
+	Sources []ConstraintSource
+	// 任意一个来源声明为required时为true
+	Required bool
+	// 所有来源声明的冲突版本范围, 已去重并排序
+	Conflicts []string
+	// 析取范式形式的约束, 外层为或, 内层为且, 已去重并排序
+	groups [][]string
+}
+
+// String 返回合并后的依赖声明, 可由ParseRequirement解析, 不含修饰符时可直接由semver.NewConstraint解析
+func (d *Dependence) String() string {
+	return formatRequirement(d.Required, formatConstraintGroups(d.groups), d.Conflicts)
+}
+
+// Dependences 服务名到依赖约束的映射
+type Dependences map[string]*Dependence
+
+// Add 合并容器对服务声明的约束, 约束格式错误或与已有约束没有交集时返回错误
+// 约束可以带有required、optional、conflicts修饰符, 见Requirement
+func (d Dependences) Add(svc, container, constraint string) error {
+	if _, err := ParseRequirement(constraint); err != nil {
+		return fmt.Errorf("%s容器对%s的依赖约束(%s)格式错误: %w", container, svc, constraint, err)
+	}
+	required, constraints, conflicts, _ := parseRequirementClauses(constraint)
+
+	dep, ok := d[svc]
+	if !ok {
+		dep = &Dependence{Service: svc, groups: [][]string{{}}}
+	}
+
+	merged := dep.groups
+	for _, c := range constraints {
+		groups, err := parseConstraintGroups(c)
+		if err != nil {
+			return fmt.Errorf("%s容器对%s的依赖约束(%s)格式错误: %w", container, svc, constraint, err)
+		}
+		merged = intersectConstraintGroups(merged, groups)
+	}
+	sources := append(dep.Sources, ConstraintSource{Container: container, Constraint: constraint})
+	if len(merged) == 0 {
+		return fmt.Errorf("对%s的依赖约束不可满足: %s", svc, formatConstraintSources(sources))
+	}
+	dep.groups = merged
+	dep.Sources = sources
+	dep.Required = dep.Required || required
+	dep.Conflicts = mergeConflicts(dep.Conflicts, conflicts)
+	d[svc] = dep
+	return nil
+}
+
+// Constraints 返回服务名到合并后约束的映射
+func (d Dependences) Constraints() map[string]string {
+	results := make(map[string]string, len(d))
+	for svc, dep := range d {
+		results[svc] = dep.String()
+	}
+	return results
+}
+
+// Sources 返回服务名到约束来源的映射
+func (d Dependences) Sources() map[string][]ConstraintSource {
+	results := make(map[string][]ConstraintSource, len(d))
+	for svc, dep := range d {
+		results[svc] = dep.Sources
+	}
+	return results
+}
+
+// MergeConstraints 对多个约束取交集, 返回规范化后的约束
+func MergeConstraints(constraints ...string) (string, error) {
+	deps := make(Dependences)
+	for i, c := range constraints {
+		if err := deps.Add("", fmt.Sprintf("#%d", i), c); err != nil {
+			return "", err
+		}
+	}
+	if dep, ok := deps[""]; ok {
+		return dep.String(), nil
+	}
+	return "", nil
+}
+
+// 将约束解析为析取范式, 每个合取项中的约束去重并排序
+func parseConstraintGroups(constraint string) ([][]string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	// Constraints.String() 以" || "分隔或, 以空格分隔且, 单个约束内不含空格
+	var groups [][]string
+	for _, or := range strings.Split(c.String(), " || ") {
+		groups = append(groups, normalizeConstraintGroup(strings.Fields(or)))
+	}
+	return dedupeConstraintGroups(groups), nil
+}
+
+// 对两个析取范式取交集, 丢弃不可满足的合取项
+func intersectConstraintGroups(a, b [][]string) [][]string {
+	var groups [][]string
+	for _, x := range a {
+		for _, y := range b {
+			group := normalizeConstraintGroup(append(append([]string{}, x...), y...))
+			if isConstraintGroupSatisfiable(group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return dedupeConstraintGroups(groups)
+}
+
+func normalizeConstraintGroup(group []string) []string {
+	seen := make(map[string]bool, len(group))
+	results := make([]string, 0, len(group))
+	for _, c := range group {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		results = append(results, c)
+	}
+	sort.Strings(results)
+	return results
+}
+
+func dedupeConstraintGroups(groups [][]string) [][]string {
+	seen := make(map[string]bool, len(groups))
+	results := make([][]string, 0, len(groups))
+	for _, g := range groups {
+		key := strings.Join(g, ", ")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		results = append(results, g)
+	}
+	return results
+}
+
+func formatConstraintGroups(groups [][]string) string {
+	ors := make([]string, len(groups))
+	for i, g := range groups {
+		ors[i] = strings.Join(g, ", ")
+	}
+	return strings.Join(ors, " || ")
+}
+
+func formatConstraintSources(sources []ConstraintSource) string {
+	results := make([]string, len(sources))
+	for i, s := range sources {
+		results[i] = s.String()
+	}
+	return strings.Join(results, ", ")
+}
+
+// 判断合取项是否可满足
+// 满足合取项的版本集合由若干区间组成, 区间的下界只可能是0.0.0、约束中出现的版本或其递增后的版本,
+// 因此只需检查这些候选版本中是否存在满足所有约束的版本
+func isConstraintGroupSatisfiable(group []string) bool {
+	c, err := semver.NewConstraint(strings.Join(group, ", "))
+	if err != nil {
+		return false
+	}
+	candidates := []semver.Version{*semver.MustParse("0.0.0")}
+	for _, raw := range group {
+		v, err := semver.NewVersion(constraintVersion(raw))
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, *v, v.IncPatch(), v.IncMinor(), v.IncMajor())
+	}
+	for i := range candidates {
+		if c.Check(&candidates[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// 提取单个约束中的版本号, 通配符按0处理
+func constraintVersion(constraint string) string {
+	v := strings.TrimLeft(constraint, "=<>!~^ ")
+	v = strings.TrimPrefix(v, "v")
+	return strings.NewReplacer("x", "0", "X", "0", "*", "0").Replace(v)
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeConstraints(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints []string
+		want        string
+		wantErr     bool
+	}{
+		{name: "single", constraints: []string{"^2.0.0"}, want: "^2.0.0"},
+		{name: "duplicate", constraints: []string{"^2.0.0", "^2.0.0", "^2.0.0"}, want: "^2.0.0"},
+		{name: "and", constraints: []string{"^2.0.0", ">=2.3.0"}, want: ">=2.3.0, ^2.0.0"},
+		{name: "merged again", constraints: []string{">=2.3.0, ^2.0.0", "^2.0.0"}, want: ">=2.3.0, ^2.0.0"},
+		{name: "or", constraints: []string{"^1.0.0 || ^2.0.0", ">=1.5.0"}, want: ">=1.5.0, ^1.0.0 || >=1.5.0, ^2.0.0"},
+		{name: "dead branch", constraints: []string{"^1.0.0 || ^2.0.0", "^2.1"}, want: "^2.0.0, ^2.1"},
+		{name: "unsatisfiable", constraints: []string{"^1", "^2"}, wantErr: true},
+		{name: "unsatisfiable range", constraints: []string{">=1.2.0, <1.2.0"}, wantErr: true},
+		{name: "unsatisfiable tilde", constraints: []string{"~1.2.0", ">1.2.x"}, wantErr: true},
+		{name: "adjacent", constraints: []string{">1.2.3", "<=1.2.4"}, want: "<=1.2.4, >1.2.3"},
+		{name: "not equal", constraints: []string{"=1.2.3", "!=1.2.3"}, wantErr: true},
+		{name: "malformed", constraints: []string{"^2.0.0", "2.x.y"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeConstraints(tt.constraints...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MergeConstraints() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("MergeConstraints() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDependences_Add(t *testing.T) {
+	deps := make(Dependences)
+	for _, s := range []ConstraintSource{
+		{Container: "wmc", Constraint: "^2.0.0"},
+		{Container: "sidecar", Constraint: "^2.0.0"},
+		{Container: "init", Constraint: ">=2.3.0"},
+	} {
+		if err := deps.Add("ocm", s.Container, s.Constraint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := deps.Add("ocm", "legacy", "^1.0.0"); err == nil {
+		t.Errorf("Add() want unsatisfiable error")
+	}
+
+	want := []ConstraintSource{
+		{Container: "wmc", Constraint: "^2.0.0"},
+		{Container: "sidecar", Constraint: "^2.0.0"},
+		{Container: "init", Constraint: ">=2.3.0"},
+	}
+	if !reflect.DeepEqual(deps["ocm"].Sources, want) {
+		t.Errorf("Sources got = %v, want %v", deps["ocm"].Sources, want)
+	}
+	if got := deps.Constraints(); !reflect.DeepEqual(got, map[string]string{"ocm": ">=2.3.0, ^2.0.0"}) {
+		t.Errorf("Constraints() got = %v", got)
+	}
+}
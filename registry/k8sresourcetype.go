@@ -115,4 +115,16 @@ const (
 	K8sLabelName            = "wkm.welljoint.com/name"        // 服务名称
 	K8sLabelVersion         = "wkm.welljoint.com/version"     // 服务版本
 	K8sAnnotationDependence = ".wkm.welljoint.com/dependence" // 依赖约束
+
+	K8sAnnotationPreviousDependence = "wkm.welljoint.com/previous-dependence" // 变更前的依赖约束
+	K8sAnnotationDependenceSources  = "wkm.welljoint.com/dependence-sources"  // 依赖约束由哪些容器声明, 值为JSON对象
+	K8sAnnotationUserDependence     = ".wkm.welljoint.com/user-dependence"    // 用户在工作负载上声明的依赖约束, mutate webhook不会修改
+	K8sAnnotationSsid               = "wkm.welljoint.com/ssid"                // 最近一次变更的会话ID
+	K8sAnnotationChangeCause        = "kubernetes.io/change-cause"            // 修订描述, 由kubectl rollout history展示
+	K8sAnnotationChangedBy          = "wkm.welljoint.com/changed-by"          // 最近一次版本变更的发起用户, 由mutate webhook写入
+
+	K8sAnnotationCapability           = ".wkm.welljoint.com/capability"            // 工作负载提供的能力版本
+	K8sAnnotationCapabilityDependence = ".wkm.welljoint.com/capability-dependence" // 对能力的依赖约束
+	// 用户在工作负载上声明的对能力的依赖约束, 能力名称不带cap_前缀, 如 ocm.api.wkm.welljoint.com/user-capability-dependence
+	K8sAnnotationUserCapabilityDependence = ".wkm.welljoint.com/user-capability-dependence"
 )
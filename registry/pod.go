@@ -1,7 +1,11 @@
 package registry
 
 import (
+	"errors"
+	"fmt"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sort"
 	"strings"
 )
 
@@ -78,3 +82,157 @@ type UpdateRequest struct {
 	// 镜像
 	Containers []Container `json:"containers" binding:"required"`
 }
+
+// Validate 检查请求参数, 与binding标签的约束一致
+func (r *UpdateRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("缺少服务名称")
+	}
+	if r.ResourceType <= 0 {
+		return errors.New("缺少资源类型")
+	}
+	if len(r.Containers) == 0 {
+		return errors.New("缺少容器")
+	}
+	for _, c := range r.Containers {
+		if c.Name == "" || c.Image == "" {
+			return errors.New("容器缺少名称或镜像")
+		}
+		if c.Type <= 0 {
+			return fmt.Errorf("容器%s缺少镜像类型", c.Name)
+		}
+	}
+	return nil
+}
+
+// ApplyContainers 将容器的镜像和环境变量写入Pod模板, 同名的环境变量被替换
+func ApplyContainers(podSpec *corev1.PodTemplateSpec, containers []Container) error {
+	for _, c := range containers {
+		targets := podSpec.Spec.Containers
+		if c.Type == ImageTypeInit {
+			targets = podSpec.Spec.InitContainers
+		}
+		target := findContainer(targets, c.Name)
+		if target == nil {
+			return fmt.Errorf("容器%s不存在", c.Name)
+		}
+		target.Image = c.Image
+		for _, e := range c.K8sEnv() {
+			if env := findEnv(target.Env, e.Name); env != nil {
+				*env = e
+			} else {
+				target.Env = append(target.Env, e)
+			}
+		}
+	}
+	return nil
+}
+
+func findContainer(containers []corev1.Container, name string) *corev1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+func findEnv(envs []corev1.EnvVar, name string) *corev1.EnvVar {
+	for i := range envs {
+		if envs[i].Name == name {
+			return &envs[i]
+		}
+	}
+	return nil
+}
+
+const (
+	DependenceEnvPrefix = "WKM_DEP_" // 被依赖服务版本的环境变量名前缀
+	DependenceEnvSuffix = "_VERSION" // 被依赖服务版本的环境变量名后缀
+
+	// WaitContainerName dictator注入的等待依赖就绪的init容器, 不参与版本和依赖约束的获取
+	WaitContainerName = "wkm-wait-for-dependencies"
+)
+
+// DependenceEnvName 被依赖服务版本的环境变量名, 如 ocm -> WKM_DEP_OCM_VERSION, ocm-api -> WKM_DEP_OCM_API_VERSION
+func DependenceEnvName(svc string) string {
+	name := strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToUpper(svc))
+	return DependenceEnvPrefix + name + DependenceEnvSuffix
+}
+
+func isDependenceEnv(name string) bool {
+	return strings.HasPrefix(name, DependenceEnvPrefix) && strings.HasSuffix(name, DependenceEnvSuffix)
+}
+
+// DependenceEnv 根据被依赖服务的版本生成环境变量, 按名称排序
+func DependenceEnv(versions map[string]string) []Env {
+	results := make([]Env, 0, len(versions))
+	for svc, version := range versions {
+		results = append(results, Env{Name: DependenceEnvName(svc), Value: version})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// SetDependenceEnv 将被依赖服务版本的环境变量写入Pod模板的所有容器, 同名的环境变量被替换,
+// 不再依赖的服务的环境变量被移除, 返回Pod模板是否有变化
+func SetDependenceEnv(podSpec *corev1.PodTemplateSpec, envs []Env) bool {
+	desired := Container{Env: envs}.K8sEnv()
+	changed := false
+	for _, containers := range [][]corev1.Container{podSpec.Spec.InitContainers, podSpec.Spec.Containers} {
+		for i := range containers {
+			target := &containers[i]
+			if target.Name == WaitContainerName {
+				continue
+			}
+			kept := make([]corev1.EnvVar, 0, len(target.Env)+len(desired))
+			for _, e := range target.Env {
+				if isDependenceEnv(e.Name) && findEnv(desired, e.Name) == nil {
+					changed = true
+					continue
+				}
+				kept = append(kept, e)
+			}
+			for _, e := range desired {
+				if env := findEnv(kept, e.Name); env == nil {
+					kept = append(kept, e)
+					changed = true
+				} else if env.Value != e.Value || env.ValueFrom != nil {
+					*env = e
+					changed = true
+				}
+			}
+			if len(kept) == 0 {
+				kept = nil
+			}
+			target.Env = kept
+		}
+	}
+	return changed
+}
+
+// DependenceVersions 获取工作负载依赖的服务在objs中的版本, 包括镜像和用户声明的依赖约束, 不存在或没有版本的服务不包含在内
+func DependenceVersions(objs map[string]runtime.Object, workload *Workload) map[string]string {
+	versions := make(map[string]string)
+	for _, deps := range []map[string]string{GetObjDependence(workload.Meta), GetObjUserDependence(workload.Meta)} {
+		for svc := range deps {
+			if _, ok := IsCapability(svc); ok {
+				continue
+			}
+			if obj := objs[svc]; obj != nil {
+				if version, _ := GetVersion(obj); version != "" {
+					versions[svc] = version
+				}
+			}
+		}
+	}
+	return versions
+}
+
+// InjectDependenceEnv 将被依赖服务的版本写入工作负载的Pod模板, 返回Pod模板是否有变化
+func InjectDependenceEnv(objs map[string]runtime.Object, workload *Workload) (bool, error) {
+	if !SetDependenceEnv(workload.Template, DependenceEnv(DependenceVersions(objs, workload))) {
+		return false, nil
+	}
+	return true, workload.Sync()
+}
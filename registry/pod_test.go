@@ -0,0 +1,100 @@
+package registry
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"testing"
+)
+
+func TestDependenceEnvName(t *testing.T) {
+	tests := []struct {
+		svc  string
+		want string
+	}{
+		{svc: "ocm", want: "WKM_DEP_OCM_VERSION"},
+		{svc: "ocm-api", want: "WKM_DEP_OCM_API_VERSION"},
+		{svc: "ocm.v2", want: "WKM_DEP_OCM_V2_VERSION"},
+	}
+	for _, tt := range tests {
+		if got := DependenceEnvName(tt.svc); got != tt.want {
+			t.Errorf("DependenceEnvName(%q) = %q, want %q", tt.svc, got, tt.want)
+		}
+	}
+}
+
+func TestSetDependenceEnv(t *testing.T) {
+	podSpec := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init"}},
+		Containers: []corev1.Container{{Name: "wmc", Env: []corev1.EnvVar{
+			{Name: "TZ", Value: "Asia/Shanghai"},
+			{Name: "WKM_DEP_OCM_VERSION", Value: "2.3.0"},
+			{Name: "WKM_DEP_CMS_VERSION", Value: "4.0.0"},
+		}}},
+	}}
+
+	envs := DependenceEnv(map[string]string{"ocm": "2.4.0", "ccs": "1.2.0"})
+	if !SetDependenceEnv(podSpec, envs) {
+		t.Fatal("SetDependenceEnv() = false, want true")
+	}
+	wantInit := []corev1.EnvVar{{Name: "WKM_DEP_CCS_VERSION", Value: "1.2.0"}, {Name: "WKM_DEP_OCM_VERSION", Value: "2.4.0"}}
+	if got := podSpec.Spec.InitContainers[0].Env; !reflect.DeepEqual(got, wantInit) {
+		t.Errorf("init env = %v, want %v", got, wantInit)
+	}
+	// 不再依赖的cms被移除, 其他环境变量保持原顺序
+	want := []corev1.EnvVar{
+		{Name: "TZ", Value: "Asia/Shanghai"},
+		{Name: "WKM_DEP_OCM_VERSION", Value: "2.4.0"},
+		{Name: "WKM_DEP_CCS_VERSION", Value: "1.2.0"},
+	}
+	if got := podSpec.Spec.Containers[0].Env; !reflect.DeepEqual(got, want) {
+		t.Errorf("env = %v, want %v", got, want)
+	}
+
+	if SetDependenceEnv(podSpec, envs) {
+		t.Error("SetDependenceEnv() with same versions = true, want false")
+	}
+	if !SetDependenceEnv(podSpec, nil) || podSpec.Spec.InitContainers[0].Env != nil {
+		t.Errorf("SetDependenceEnv() without dependences, init env = %v, want nil", podSpec.Spec.InitContainers[0].Env)
+	}
+}
+
+func TestDependenceVersions(t *testing.T) {
+	newDeployment := func(name, version string, deps map[string]string) *appsv1.Deployment {
+		d := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: name, Image: "harbor:5000/wecloud/" + name + ":" + version}},
+			}}},
+		}
+		SetObjVersion(d, version, deps)
+		return d
+	}
+	wmc := newDeployment("wmc", "1.8.1", map[string]string{"ocm": "^2.0.0", "ccs": "^1.0.0", CapabilityPrefix + "ocm.api": "^2.0"})
+	wmc.Annotations["cms"+K8sAnnotationUserDependence] = "^4.0.0"
+	objs := map[string]runtime.Object{
+		"wmc": wmc,
+		"ocm": newDeployment("ocm", "2.4.0", nil),
+		"cms": newDeployment("cms", "4.1.0", nil),
+	}
+	workload, _ := GetWorkload(wmc)
+
+	// 不存在的ccs和能力依赖不包含在内
+	want := map[string]string{"ocm": "2.4.0", "cms": "4.1.0"}
+	if got := DependenceVersions(objs, workload); !reflect.DeepEqual(got, want) {
+		t.Errorf("DependenceVersions() = %v, want %v", got, want)
+	}
+	changed, err := InjectDependenceEnv(objs, workload)
+	if err != nil || !changed {
+		t.Fatalf("InjectDependenceEnv() = %v, %v", changed, err)
+	}
+	wantEnv := []corev1.EnvVar{{Name: "WKM_DEP_CMS_VERSION", Value: "4.1.0"}, {Name: "WKM_DEP_OCM_VERSION", Value: "2.4.0"}}
+	if got := wmc.Spec.Template.Spec.Containers[0].Env; !reflect.DeepEqual(got, wantEnv) {
+		t.Errorf("InjectDependenceEnv() env = %v, want %v", got, wantEnv)
+	}
+	if changed, _ = InjectDependenceEnv(objs, workload); changed {
+		t.Error("InjectDependenceEnv() with same versions = true, want false")
+	}
+}
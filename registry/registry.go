@@ -1,14 +1,27 @@
 package registry
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"io"
+	"k8s.io/klog/v2"
 	"os"
 	"path"
+	"reflect"
 	"strings"
 )
 
+const (
+	ImageLabelDependencePrefix = "ver_"                                // 镜像配置中依赖约束label的前缀
+	ImageAnnotationDependence  = "com.welljoint.wkm.dependence"        // 镜像清单中依赖约束的annotation, 值为JSON对象
+	ArtifactTypeDependence     = "application/vnd.wkm.dependence+json" // 通过referrers关联的依赖清单的artifactType
+	ImageLabelCapabilityPrefix = "cap_"                                // 镜像配置中提供的能力label的前缀, 如 cap_ocm.api=2.3
+)
+
 func getAuth(ref name.Reference) (authn.Authenticator, error) {
 	homedir, err := os.UserHomeDir()
 	if err != nil {
@@ -22,34 +35,273 @@ func getAuth(ref name.Reference) (authn.Authenticator, error) {
 }
 
 func GetImageDependenceRaw(image string) (map[string]string, error) {
+	return GetImageDependenceRawForPlatform(image, nil)
+}
+
+// 镜像声明的依赖约束和提供的能力
+type imageMetadata struct {
+	dependences  map[string]string
+	capabilities map[string]string
+}
+
+// GetImageDependenceRawForPlatform 获取镜像的依赖约束
+// 镜像为多架构索引时, 只读取platform对应的镜像, platform为空时读取所有平台, 并要求各平台声明的依赖约束一致
+//
+// 依赖约束有三个来源, 同一服务按优先级从低到高覆盖:
+//  1. 镜像配置中ver_前缀的label, 如 ver_ocm=^2.0.0
+//  2. 镜像清单的com.welljoint.wkm.dependence annotation, 值为JSON对象, 如 {"ocm":"^2.0.0"}
+//  3. 通过OCI referrers关联到镜像的依赖清单(artifactType为application/vnd.wkm.dependence+json), 内容格式同2
+//
+// 多架构索引清单的annotation和关联到索引的referrers作用于所有平台, 优先级低于平台镜像自身的同类来源
+// 仓库不支持referrers或查询referrers失败时忽略来源3
+//
+// 约束可以带有required、optional、conflicts修饰符, 如 ver_ocm=required:^2.0.0, 见Requirement
+// 对能力的依赖约束以cap_前缀的服务名声明, 如 ver_cap_ocm.api=^2.0, 见Capability
+func GetImageDependenceRawForPlatform(image string, platform *v1.Platform) (map[string]string, error) {
+	deps, _, err := GetImageMetadataForPlatform(image, platform)
+	return deps, err
+}
+
+// GetImageMetadataForPlatform 获取镜像的依赖约束和提供的能力
+// 依赖约束同GetImageDependenceRawForPlatform, 提供的能力来自镜像配置中cap_前缀的label, 版本补全为x.y.z
+func GetImageMetadataForPlatform(image string, platform *v1.Platform) (map[string]string, map[string]string, error) {
 	ref, err := name.ParseReference(image, name.Insecure)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	auth, err := getAuth(ref)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	desc, err := remote.Get(ref, remote.WithAuth(auth))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := []remote.Option{remote.WithAuth(auth)}
+	digest := ref.Context().Digest(desc.Digest.String())
+	var results *imageMetadata
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, nil, err
+		}
+		results, err = getIndexDependence(image, digest, index, platform, options...)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		images, err := desc.Image()
+		if err != nil {
+			return nil, nil, err
+		}
+		results, err = getImageDependence(digest, images, nil, options...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return results.dependences, results.capabilities, nil
+}
+
+// 多架构镜像索引本身声明的依赖约束, 作用于索引中所有平台的镜像
+type indexDependence struct {
+	annotations map[string]string // 索引清单的annotation
+	referrers   map[string]string // 通过referrers关联到索引的依赖清单
+}
+
+// 获取镜像的依赖约束和提供的能力, digest为镜像清单的摘要, 用于查询referrers
+// 镜像属于多架构索引时, 索引声明的约束按来源插入到镜像自身的同类来源之前, 即平台镜像的声明优先
+func getImageDependence(digest name.Digest, image v1.Image, index *indexDependence, options ...remote.Option) (*imageMetadata, error) {
+	cfg, err := image.ConfigFile()
 	if err != nil {
 		return nil, err
 	}
 
-	images, err := desc.Image()
+	results := &imageMetadata{
+		dependences:  make(map[string]string, len(cfg.Config.Labels)),
+		capabilities: make(map[string]string),
+	}
+	for k, v := range cfg.Config.Labels {
+		if len(k) > len(ImageLabelCapabilityPrefix) && strings.HasPrefix(k, ImageLabelCapabilityPrefix) {
+			capability := k[len(ImageLabelCapabilityPrefix):]
+			version, err := parseCapabilityVersion(capability, v)
+			if err != nil {
+				return nil, fmt.Errorf("解析镜像label %s失败: %w", k, err)
+			}
+			results.capabilities[capability] = version
+			continue
+		}
+		if len(k) <= len(ImageLabelDependencePrefix) || !strings.HasPrefix(k, ImageLabelDependencePrefix) {
+			continue
+		}
+		results.dependences[k[len(ImageLabelDependencePrefix):]] = v
+	}
+	if index == nil {
+		index = &indexDependence{}
+	}
+
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := parseDependenceAnnotation(manifest.Annotations)
 	if err != nil {
 		return nil, err
 	}
-	cfg, err := images.ConfigFile()
+	referrers, err := getReferrersDependence(digest, options...)
 	if err != nil {
 		return nil, err
 	}
+	for _, deps := range []map[string]string{index.annotations, annotations, index.referrers, referrers} {
+		for k, v := range deps {
+			results.dependences[k] = v
+		}
+	}
+	return results, nil
+}
 
-	results := make(map[string]string, len(cfg.Config.Labels))
-	for k, v := range cfg.Config.Labels {
-		if len(k) <= 4 || !strings.HasPrefix(k, "ver_") {
+// 解析清单annotation中的依赖约束
+func parseDependenceAnnotation(annotations map[string]string) (map[string]string, error) {
+	raw := annotations[ImageAnnotationDependence]
+	if raw == "" {
+		return nil, nil
+	}
+	deps, err := parseDependenceJSON([]byte(raw))
+	if err != nil {
+		return nil, fmt.Errorf("解析镜像annotation %s失败: %w", ImageAnnotationDependence, err)
+	}
+	return deps, nil
+}
+
+// 获取通过referrers关联到镜像的依赖清单
+// 存在多个依赖清单时合并, 同一服务的约束不一致则报错
+// 仓库不支持referrers或查询失败时视为没有关联的依赖清单
+func getReferrersDependence(digest name.Digest, options ...remote.Option) (map[string]string, error) {
+	options = append(options, remote.WithFilter("artifactType", ArtifactTypeDependence))
+	index, err := remote.Referrers(digest, options...)
+	if err != nil {
+		klog.V(2).Infof("查询referrers失败, 忽略: %s %v\n", digest, err)
+		return nil, nil
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		klog.V(2).Infof("查询referrers失败, 忽略: %s %v\n", digest, err)
+		return nil, nil
+	}
+
+	results := make(map[string]string)
+	for _, m := range manifest.Manifests {
+		artifact, err := remote.Image(digest.Context().Digest(m.Digest.String()), options...)
+		if err != nil {
+			return nil, err
+		}
+		layers, err := artifact.Layers()
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range layers {
+			deps, err := readDependenceLayer(l)
+			if err != nil {
+				return nil, fmt.Errorf("解析依赖清单%s失败: %w", m.Digest, err)
+			}
+			for k, v := range deps {
+				if got, ok := results[k]; ok && got != v {
+					return nil, fmt.Errorf("依赖清单中%s的依赖约束冲突，%s，%s", k, got, v)
+				}
+				results[k] = v
+			}
+		}
+	}
+	return results, nil
+}
+
+func readDependenceLayer(l v1.Layer) (map[string]string, error) {
+	// 依赖清单不压缩, 直接读取原始blob
+	rc, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	raw, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return parseDependenceJSON(raw)
+}
+
+func parseDependenceJSON(raw []byte) (map[string]string, error) {
+	deps := make(map[string]string)
+	if err := json.Unmarshal(raw, &deps); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// 从多架构镜像索引中获取依赖约束和提供的能力
+// 索引清单的annotation和关联到索引的referrers作用于所有平台, 平台镜像自身的声明优先
+func getIndexDependence(image string, digest name.Digest, index v1.ImageIndex, platform *v1.Platform, options ...remote.Option) (*imageMetadata, error) {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := parseDependenceAnnotation(manifest.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	referrers, err := getReferrersDependence(digest, options...)
+	if err != nil {
+		return nil, err
+	}
+	shared := &indexDependence{annotations: annotations, referrers: referrers}
+	// 只指定了架构(如nodeSelector只设置了kubernetes.io/arch)时操作系统默认为linux
+	// 否则Satisfies会匹配任意操作系统, 可能读取到windows等其他系统的镜像
+	if platform != nil && platform.OS == "" {
+		p := *platform
+		p.OS = "linux"
+		platform = &p
+	}
+
+	var results *imageMetadata
+	var resultsPlatform v1.Platform
+	for _, m := range manifest.Manifests {
+		// 跳过没有平台信息的子清单, 如buildx生成的attestation清单(unknown/unknown)
+		if m.Platform == nil || m.Platform.OS == "unknown" || !m.MediaType.IsImage() {
 			continue
 		}
-		results[k[4:]] = v
+		if platform != nil && !m.Platform.Satisfies(*platform) {
+			continue
+		}
+
+		child, err := index.Image(m.Digest)
+		if err != nil {
+			return nil, err
+		}
+		deps, err := getImageDependence(digest.Context().Digest(m.Digest.String()), child, shared, options...)
+		if err != nil {
+			return nil, err
+		}
+		if platform != nil {
+			return deps, nil
+		}
+
+		// 未指定平台时, 工作负载可能被调度到任意平台, 各平台的依赖约束和提供的能力必须一致
+		if results == nil {
+			results, resultsPlatform = deps, *m.Platform
+			continue
+		}
+		if !reflect.DeepEqual(results.dependences, deps.dependences) {
+			return nil, fmt.Errorf("镜像%s各平台的依赖约束冲突，%s: %v，%s: %v", image, resultsPlatform, results.dependences, m.Platform, deps.dependences)
+		}
+		if !reflect.DeepEqual(results.capabilities, deps.capabilities) {
+			return nil, fmt.Errorf("镜像%s各平台提供的能力冲突，%s: %v，%s: %v", image, resultsPlatform, results.capabilities, m.Platform, deps.capabilities)
+		}
+	}
+
+	if platform != nil {
+		return nil, fmt.Errorf("镜像%s不包含平台%s", image, platform)
+	}
+	if results == nil {
+		return nil, fmt.Errorf("镜像%s不包含可用的平台", image)
 	}
 	return results, nil
 }
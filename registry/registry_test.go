@@ -1,7 +1,22 @@
 package registry
 
 import (
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -39,3 +54,223 @@ func TestGetImageDependenceRaw(t *testing.T) {
 		})
 	}
 }
+
+// 启动内存镜像仓库并推送测试镜像, 返回仓库地址
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	s := httptest.NewServer(ggcrregistry.New(
+		ggcrregistry.Logger(log.New(io.Discard, "", 0)),
+		ggcrregistry.WithReferrersSupport(true),
+	))
+	t.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// 启动referrers接口总是失败的内存镜像仓库, 返回仓库地址
+func newTestRegistryWithoutReferrers(t *testing.T) string {
+	t.Helper()
+	handler := ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0)))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/referrers/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func newTestImage(t *testing.T, labels map[string]string) v1.Image {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.Labels = labels
+	img, err = mutate.ConfigFile(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func newTestIndex(t *testing.T, images map[string]v1.Image) v1.ImageIndex {
+	t.Helper()
+	adds := make([]mutate.IndexAddendum, 0, len(images))
+	for p, img := range images {
+		platform, err := v1.ParsePlatform(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: platform}})
+	}
+	return mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), adds...)
+}
+
+func TestGetImageDependenceRawForPlatform(t *testing.T) {
+	host := newTestRegistry(t)
+	push := func(tag string, index v1.ImageIndex) string {
+		image := host + "/wecloud/wmc:" + tag
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = remote.WriteIndex(ref, index); err != nil {
+			t.Fatal(err)
+		}
+		return image
+	}
+
+	same := push("same", newTestIndex(t, map[string]v1.Image{
+		"linux/arm64":     newTestImage(t, map[string]string{"ver_ocm": "^2.0.0"}),
+		"linux/arm/v7":    newTestImage(t, map[string]string{"ver_ocm": "^2.0.0"}),
+		"unknown/unknown": newTestImage(t, nil),
+	}))
+	conflict := push("conflict", newTestIndex(t, map[string]v1.Image{
+		"linux/amd64": newTestImage(t, map[string]string{"ver_ocm": "^2.0.0"}),
+		"linux/arm64": newTestImage(t, map[string]string{"ver_ocm": "^1.0.0"}),
+	}))
+	// windows镜像排在linux镜像之前
+	mixed := push("mixed", mutate.AppendManifests(newTestIndex(t, map[string]v1.Image{
+		"windows/amd64": newTestImage(t, map[string]string{"ver_ocm": "^1.0.0"}),
+	}), mutate.IndexAddendum{
+		Add:        newTestImage(t, map[string]string{"ver_ocm": "^2.0.0"}),
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+	}))
+
+	tests := []struct {
+		name     string
+		image    string
+		platform *v1.Platform
+		want     map[string]string
+		wantErr  bool
+	}{
+		{name: "index without amd64", image: same, want: map[string]string{"ocm": "^2.0.0"}},
+		{name: "conflict", image: conflict, wantErr: true},
+		{name: "conflict amd64", image: conflict, platform: &v1.Platform{OS: "linux", Architecture: "amd64"}, want: map[string]string{"ocm": "^2.0.0"}},
+		{name: "conflict arm64", image: conflict, platform: &v1.Platform{Architecture: "arm64"}, want: map[string]string{"ocm": "^1.0.0"}},
+		{name: "mixed os amd64", image: mixed, platform: &v1.Platform{Architecture: "amd64"}, want: map[string]string{"ocm": "^2.0.0"}},
+		{name: "mixed os windows", image: mixed, platform: &v1.Platform{OS: "windows", Architecture: "amd64"}, want: map[string]string{"ocm": "^1.0.0"}},
+		{name: "missing platform", image: same, platform: &v1.Platform{OS: "linux", Architecture: "s390x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetImageDependenceRawForPlatform(tt.image, tt.platform)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetImageDependenceRawForPlatform() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetImageDependenceRawForPlatform() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetImageDependenceRawSources(t *testing.T) {
+	host := newTestRegistry(t)
+	push := func(tag string, img v1.Image) (string, name.Reference) {
+		image := host + "/wecloud/wmc:" + tag
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+		return image, ref
+	}
+	attach := func(ref name.Reference, subject partial.Describable, content string) {
+		desc, err := partial.Descriptor(subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		artifact, err := mutate.Append(empty.Image, mutate.Addendum{
+			Layer:     static.NewLayer([]byte(content), ArtifactTypeDependence),
+			MediaType: ArtifactTypeDependence,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		artifact = mutate.ConfigMediaType(mutate.MediaType(artifact, types.OCIManifestSchema1), ArtifactTypeDependence)
+		artifact = mutate.Subject(artifact, *desc).(v1.Image)
+		digest, err := artifact.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = remote.Write(ref.Context().Digest(digest.String()), artifact); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	labels := newTestImage(t, map[string]string{"ver_ocm": "^1.0.0", "ver_cms": "^4.0.0"})
+	labelsImage, _ := push("labels", labels)
+
+	annotated := mutate.Annotations(labels, map[string]string{ImageAnnotationDependence: `{"ocm":"^2.0.0"}`}).(v1.Image)
+	annotatedImage, _ := push("annotated", annotated)
+
+	referred := mutate.Annotations(newTestImage(t, map[string]string{"ver_ocm": "^1.0.0", "ver_cms": "^4.0.0"}),
+		map[string]string{ImageAnnotationDependence: `{"ocm":"^2.0.0"}`}).(v1.Image)
+	referredImage, referredRef := push("referred", referred)
+	attach(referredRef, referred, `{"ocm":"^3.0.0","ccs":"~1.2.0"}`)
+
+	// 索引的annotation和referrers作用于所有平台, 平台镜像的referrers优先
+	child := newTestImage(t, map[string]string{"ver_ocm": "^1.0.0"})
+	index := mutate.Annotations(newTestIndex(t, map[string]v1.Image{"linux/amd64": child}),
+		map[string]string{ImageAnnotationDependence: `{"ocm":"^2.0.0","cms":"^4.0.0"}`}).(v1.ImageIndex)
+	indexImage := host + "/wecloud/wmc:index"
+	indexRef, err := name.ParseReference(indexImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.WriteIndex(indexRef, index); err != nil {
+		t.Fatal(err)
+	}
+	attach(indexRef, index, `{"ccs":"~1.2.0","cms":"^4.1.0"}`)
+	attach(indexRef, child, `{"ccs":"~1.3.0"}`)
+
+	// 仓库的referrers接口失败时忽略referrers
+	failingImage := newTestRegistryWithoutReferrers(t) + "/wecloud/wmc:annotated"
+	failingRef, err := name.ParseReference(failingImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(failingRef, annotated); err != nil {
+		t.Fatal(err)
+	}
+
+	badAnnotation := mutate.Annotations(newTestImage(t, nil), map[string]string{ImageAnnotationDependence: `ocm=^2.0.0`}).(v1.Image)
+	badAnnotationImage, _ := push("bad", badAnnotation)
+
+	tests := []struct {
+		name    string
+		image   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "labels", image: labelsImage, want: map[string]string{"ocm": "^1.0.0", "cms": "^4.0.0"}},
+		{name: "annotation over label", image: annotatedImage, want: map[string]string{"ocm": "^2.0.0", "cms": "^4.0.0"}},
+		{name: "referrer over annotation", image: referredImage, want: map[string]string{"ocm": "^3.0.0", "cms": "^4.0.0", "ccs": "~1.2.0"}},
+		{name: "index and child", image: indexImage, want: map[string]string{"ocm": "^2.0.0", "cms": "^4.1.0", "ccs": "~1.3.0"}},
+		{name: "failing referrers", image: failingImage, want: map[string]string{"ocm": "^2.0.0", "cms": "^4.0.0"}},
+		{name: "malformed annotation", image: badAnnotationImage, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetImageDependenceRaw(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetImageDependenceRaw() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetImageDependenceRaw() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"sort"
+	"strings"
+)
+
+const (
+	ModifierRequired  = "required"  // 被依赖的服务必须存在
+	ModifierOptional  = "optional"  // 被依赖的服务不存在时不检查, 默认
+	ModifierConflicts = "conflicts" // 被依赖的服务不能运行约束范围内的版本
+)
+
+// Requirement 对某个服务的依赖声明
+// 格式为以";"分隔的若干子句, 每个子句为"[修饰符:]约束", 省略修饰符时为optional, 如:
+//
+//	^2.0.0                          ocm存在时版本须满足^2.0.0
+//	required:^2.0.0                 ocm必须存在且版本满足^2.0.0
+//	required                        ocm必须存在, 版本不限
+//	^2.0.0; conflicts:2.3.1         ocm存在时版本须满足^2.0.0且不能为2.3.1
+//	conflicts:>=3.0.0, <3.1.0       ocm存在时不能运行3.0.x
+type Requirement struct {
+	Required bool
+	// 版本约束, 为空时不限
+	Constraint string
+	// 冲突的版本范围, 满足其中任意一个即冲突
+	Conflicts []string
+
+	constraint *semver.Constraints
+	conflicts  []*semver.Constraints
+}
+
+// ParseRequirement 解析依赖声明
+func ParseRequirement(value string) (*Requirement, error) {
+	required, constraints, conflicts, err := parseRequirementClauses(value)
+	if err != nil {
+		return nil, err
+	}
+	r := &Requirement{Required: required, Conflicts: conflicts}
+	if len(constraints) > 0 {
+		// 各子句的约束可能含有"||", 以析取范式取交集, 不能直接以", "拼接
+		var merged [][]string
+		for i, c := range constraints {
+			groups, err := parseConstraintGroups(c)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				merged = groups
+				continue
+			}
+			merged = intersectConstraintGroups(merged, groups)
+		}
+		if len(merged) == 0 {
+			return nil, fmt.Errorf("依赖约束不可满足: %s", strings.Join(constraints, "; "))
+		}
+		r.Constraint = formatConstraintGroups(merged)
+		if r.constraint, err = semver.NewConstraint(r.Constraint); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range conflicts {
+		conflict, err := semver.NewConstraint(c)
+		if err != nil {
+			return nil, err
+		}
+		r.conflicts = append(r.conflicts, conflict)
+	}
+	return r, nil
+}
+
+// 拆分依赖声明的子句, 返回是否必需、版本约束和冲突的版本范围, 只检查修饰符, 不解析约束
+func parseRequirementClauses(value string) (required bool, constraints, conflicts []string, err error) {
+	for _, clause := range strings.Split(value, ";") {
+		clause = strings.TrimSpace(clause)
+		modifier, constraint := ModifierOptional, clause
+		if i := strings.IndexByte(clause, ':'); i >= 0 {
+			modifier, constraint = strings.TrimSpace(clause[:i]), strings.TrimSpace(clause[i+1:])
+		} else if clause == ModifierRequired || clause == ModifierOptional {
+			modifier, constraint = clause, ""
+		}
+		switch modifier {
+		case ModifierRequired:
+			required = true
+		case ModifierOptional:
+		case ModifierConflicts:
+			if constraint == "" {
+				return false, nil, nil, fmt.Errorf("%s缺少版本范围", ModifierConflicts)
+			}
+			conflicts = append(conflicts, constraint)
+			continue
+		default:
+			return false, nil, nil, fmt.Errorf("未知的修饰符%s", modifier)
+		}
+		if constraint != "" {
+			constraints = append(constraints, constraint)
+		} else if modifier == ModifierOptional && clause != ModifierOptional {
+			return false, nil, nil, fmt.Errorf("空的依赖约束")
+		}
+	}
+	return required, constraints, conflicts, nil
+}
+
+// Matches 判断版本是否满足版本约束
+func (r *Requirement) Matches(v *semver.Version) bool {
+	return r.constraint == nil || r.constraint.Check(v)
+}
+
+// Conflict 返回版本命中的冲突范围, 未命中时返回空
+func (r *Requirement) Conflict(v *semver.Version) string {
+	for i, c := range r.conflicts {
+		if c.Check(v) {
+			return r.Conflicts[i]
+		}
+	}
+	return ""
+}
+
+func (r *Requirement) String() string {
+	return formatRequirement(r.Required, r.Constraint, r.Conflicts)
+}
+
+func formatRequirement(required bool, constraint string, conflicts []string) string {
+	var clauses []string
+	switch {
+	case required && constraint == "":
+		clauses = append(clauses, ModifierRequired)
+	case required:
+		clauses = append(clauses, ModifierRequired+":"+constraint)
+	case constraint != "":
+		clauses = append(clauses, constraint)
+	}
+	for _, c := range conflicts {
+		clauses = append(clauses, ModifierConflicts+":"+c)
+	}
+	return strings.Join(clauses, "; ")
+}
+
+// 合并冲突的版本范围, 去重并排序
+func mergeConflicts(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var results []string
+	for _, c := range append(append([]string{}, a...), b...) {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		results = append(results, c)
+	}
+	sort.Strings(results)
+	return results
+}
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"github.com/Masterminds/semver/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseRequirement(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      string
+		required  bool
+		matches   []string
+		rejects   []string
+		conflicts []string
+		wantErr   bool
+	}{
+		{value: "^2.0.0", want: "^2.0.0", matches: []string{"2.3.0"}},
+		{value: "optional:^2.0.0", want: "^2.0.0", matches: []string{"2.3.0"}},
+		{value: "required:^2.0.0", want: "required:^2.0.0", required: true, matches: []string{"2.3.0"}},
+		{value: "required", want: "required", required: true, matches: []string{"1.0.0", "3.0.0"}},
+		{value: "^2.0.0; conflicts:2.3.1", want: "^2.0.0; conflicts:2.3.1", matches: []string{"2.3.0"}, conflicts: []string{"2.3.1"}},
+		{value: " conflicts: >=3.0.0, <3.1.0 ", want: "conflicts:>=3.0.0, <3.1.0", matches: []string{"2.0.0", "3.1.0"}, conflicts: []string{"3.0.5"}},
+		{value: "required; ^2.0.0", want: "required:^2.0.0", required: true, matches: []string{"2.0.0"}},
+		{value: "^1.0.0 || ^2.0.0; >=1.5.0", want: ">=1.5.0, ^1.0.0 || >=1.5.0, ^2.0.0", matches: []string{"1.5.0", "2.0.0"}, rejects: []string{"1.0.0"}},
+		{value: "^1.0.0; ^2.0.0", wantErr: true},
+		{value: "latest:^2.0.0", wantErr: true},
+		{value: "conflicts:", wantErr: true},
+		{value: "^2.0.0;", wantErr: true},
+		{value: "required:2.x.y", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			r, err := ParseRequirement(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRequirement() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if r.String() != tt.want || r.Required != tt.required {
+				t.Errorf("ParseRequirement() = %q required %v, want %q required %v", r.String(), r.Required, tt.want, tt.required)
+			}
+			for _, v := range tt.matches {
+				if version := semver.MustParse(v); !r.Matches(version) || r.Conflict(version) != "" {
+					t.Errorf("ParseRequirement(%q) rejects %s", tt.value, v)
+				}
+			}
+			for _, v := range tt.rejects {
+				if r.Matches(semver.MustParse(v)) {
+					t.Errorf("ParseRequirement(%q) matches %s", tt.value, v)
+				}
+			}
+			for _, v := range tt.conflicts {
+				if r.Conflict(semver.MustParse(v)) == "" {
+					t.Errorf("ParseRequirement(%q) does not conflict with %s", tt.value, v)
+				}
+			}
+		})
+	}
+}
+
+func TestDependences_AddModifiers(t *testing.T) {
+	deps := make(Dependences)
+	for _, s := range []ConstraintSource{
+		{Container: "wmc", Constraint: "^2.0.0; conflicts:2.3.1"},
+		{Container: "sidecar", Constraint: "required:>=2.2.0"},
+		{Container: "init", Constraint: "conflicts:2.2.5"},
+	} {
+		if err := deps.Add("ocm", s.Container, s.Constraint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := deps["ocm"].String(), "required:>=2.2.0, ^2.0.0; conflicts:2.2.5; conflicts:2.3.1"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if err := deps.Add("ocm", "legacy", "required:^1.0.0"); err == nil {
+		t.Error("Add() want unsatisfiable error")
+	}
+}
+
+func TestCheckForwardDependence_Modifiers(t *testing.T) {
+	ocm := &appsv1.Deployment{ObjectMeta: v12.ObjectMeta{Name: "ocm"}}
+	SetObjVersion(ocm, "2.3.1", nil)
+	objs := map[string]runtime.Object{"ocm": ocm}
+
+	tests := []struct {
+		name    string
+		deps    map[string]string
+		wantErr string
+	}{
+		{name: "optional missing", deps: map[string]string{"cms": "^4.0.0"}},
+		{name: "required missing", deps: map[string]string{"cms": "required:^4.0.0"}, wantErr: "缺少"},
+		{name: "required present", deps: map[string]string{"ocm": "required"}},
+		{name: "conflicts", deps: map[string]string{"ocm": "^2.0.0; conflicts:2.3.1"}, wantErr: "冲突"},
+		{name: "not conflicting", deps: map[string]string{"ocm": "conflicts:>=3.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckForwardDependence(objs, tt.deps)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("CheckForwardDependence() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	wmc := &v12.ObjectMeta{Name: "wmc", Annotations: map[string]string{"ocm" + K8sAnnotationDependence: "conflicts:2.3.1"}}
+	if err := CheckReverseDependence(map[string]v12.Object{"wmc": wmc}, "ocm", "2.3.1"); err == nil || !strings.Contains(err.Error(), "冲突") {
+		t.Errorf("CheckReverseDependence() error = %v, want conflict", err)
+	}
+}
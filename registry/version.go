@@ -1,25 +1,36 @@
 package registry
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Masterminds/semver/v3"
-	appsv1 "k8s.io/api/apps/v1"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	corev1 "k8s.io/api/core/v1"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/klog/v2"
 	_ "net/http"
+	"reflect"
+	"sort"
 	"strings"
 )
 
+// 依次返回init容器和普通容器, 跳过dictator注入的等待依赖的容器
+func podContainers(podSpec *corev1.PodTemplateSpec) []corev1.Container {
+	containers := make([]corev1.Container, 0, len(podSpec.Spec.InitContainers)+len(podSpec.Spec.Containers))
+	for _, c := range podSpec.Spec.InitContainers {
+		if c.Name != WaitContainerName {
+			containers = append(containers, c)
+		}
+	}
+	return append(containers, podSpec.Spec.Containers...)
+}
+
 // 获取版本
 // 从init容器和普通容器中依次遍历, 找到第一个符合语义化版本的镜像tag
 func getVersionByPodTemplate(podSpec *corev1.PodTemplateSpec) string {
-	containers := make([]corev1.Container, 0, len(podSpec.Spec.InitContainers)+len(podSpec.Spec.Containers))
-	containers = append(containers, podSpec.Spec.InitContainers...)
-	containers = append(containers, podSpec.Spec.Containers...)
-	for _, c := range containers {
+	for _, c := range podContainers(podSpec) {
 		i := strings.LastIndexByte(c.Image, ':')
 		if i == -1 {
 			continue
@@ -34,75 +45,149 @@ func getVersionByPodTemplate(podSpec *corev1.PodTemplateSpec) string {
 	return ""
 }
 
-// 获取依赖约束
-// 从init容器和普通容器中依次遍历, 获取每个镜像的依赖约束
-func getDependenceByPodTemplate(podSpec *corev1.PodTemplateSpec) (map[string]string, error) {
-	deps := make(map[string]string)
+// 获取依赖约束和提供的能力
+// 从init容器和普通容器中依次遍历, 获取每个镜像的依赖约束, 多个容器对同一服务的约束取交集
+// 多个容器提供同一能力时版本必须一致, nodeSelector未指定平台时读取defaultPlatform对应的镜像
+func getDependenceByPodTemplate(podSpec *corev1.PodTemplateSpec, defaultPlatform *v1.Platform) (Dependences, map[string]string, error) {
+	deps := make(Dependences)
+	capabilities := make(map[string]string)
 
-	containers := make([]corev1.Container, 0, len(podSpec.Spec.InitContainers)+len(podSpec.Spec.Containers))
-	containers = append(containers, podSpec.Spec.InitContainers...)
-	containers = append(containers, podSpec.Spec.Containers...)
-	for _, c := range containers {
+	platform := getPlatformByPodTemplate(podSpec)
+	if platform == nil {
+		platform = defaultPlatform
+	}
+	for _, c := range podContainers(podSpec) {
 		i := strings.LastIndexByte(c.Image, ':')
 		if i == -1 {
 			continue
 		}
 
-		dependence, err := GetImageDependenceRaw(c.Image)
+		dependence, provided, err := GetImageMetadataForPlatform(c.Image, platform)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		for k, v := range dependence {
-			if got, ok := deps[k]; ok {
-				deps[k] = got + "," + v
-			} else {
-				deps[k] = v
+			if err = deps.Add(k, c.Name, v); err != nil {
+				return nil, nil, err
+			}
+		}
+		for k, v := range provided {
+			if got, ok := capabilities[k]; ok && got != v {
+				return nil, nil, fmt.Errorf("容器提供的能力%s版本冲突，%s，%s(%s)", k, got, c.Name, v)
 			}
+			capabilities[k] = v
 		}
 	}
 
-	return deps, nil
+	return deps, capabilities, nil
+}
+
+// 获取平台
+// 从nodeSelector中读取操作系统和架构, 未指定时返回nil
+func getPlatformByPodTemplate(podSpec *corev1.PodTemplateSpec) *v1.Platform {
+	os := podSpec.Spec.NodeSelector[corev1.LabelOSStable]
+	arch := podSpec.Spec.NodeSelector[corev1.LabelArchStable]
+	if os == "" && arch == "" {
+		return nil
+	}
+	return &v1.Platform{OS: os, Architecture: arch}
+}
+
+// GetPodVersion 获取Pod的版本
+func GetPodVersion(pod *corev1.Pod) string {
+	return getVersionByPodTemplate(&corev1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec})
 }
 
 // GetVersionAndDependence 从远程私人仓库获取版本和依赖约束
-func GetVersionAndDependence(podSpec corev1.PodTemplateSpec) (string, map[string]string, error) {
-	version := getVersionByPodTemplate(&podSpec)
-	deps, err := getDependenceByPodTemplate(&podSpec)
+// 多架构镜像未通过nodeSelector指定平台时读取defaultPlatform, 为空时读取所有平台
+func GetVersionAndDependence(podSpec corev1.PodTemplateSpec, defaultPlatform *v1.Platform) (string, Dependences, error) {
+	version, deps, _, err := GetVersionDependenceAndCapability(podSpec, defaultPlatform)
 	return version, deps, err
 }
 
+// GetVersionDependenceAndCapability 从远程私人仓库获取版本、依赖约束和提供的能力
+func GetVersionDependenceAndCapability(podSpec corev1.PodTemplateSpec, defaultPlatform *v1.Platform) (string, Dependences, map[string]string, error) {
+	version := getVersionByPodTemplate(&podSpec)
+	deps, capabilities, err := getDependenceByPodTemplate(&podSpec, defaultPlatform)
+	return version, deps, capabilities, err
+}
+
 func CheckForwardDependence(objs map[string]runtime.Object, deps map[string]string) error {
+	return CheckForwardDependenceWithVersions(objs, objVersions(objs), deps)
+}
+
+func objVersions(objs map[string]runtime.Object) map[string][]string {
+	versions := make(map[string][]string, len(objs))
+	for svc, obj := range objs {
+		if version, _ := GetVersion(obj); version != "" {
+			versions[svc] = []string{version}
+		}
+	}
+	return versions
+}
+
+// CheckForwardDependenceWithVersions 正向依赖检查, versions为被依赖服务的所有版本(如滚动更新中新旧ReplicaSet的版本), 每个版本都需要符合约束
+func CheckForwardDependenceWithVersions(objs map[string]runtime.Object, versions map[string][]string, deps map[string]string) error {
+	return checkForwardDependence(objs, versions, deps, false)
+}
+
+// CheckUserForwardDependence 对用户在工作负载上声明的依赖约束进行正向依赖检查, 错误中注明约束来源
+func CheckUserForwardDependence(objs map[string]runtime.Object, deps map[string]string) error {
+	return CheckUserForwardDependenceWithVersions(objs, objVersions(objs), deps)
+}
+
+// CheckUserForwardDependenceWithVersions 同CheckForwardDependenceWithVersions, 用于用户声明的依赖约束
+func CheckUserForwardDependenceWithVersions(objs map[string]runtime.Object, versions map[string][]string, deps map[string]string) error {
+	return checkForwardDependence(objs, versions, deps, true)
+}
+
+func checkForwardDependence(objs map[string]runtime.Object, versions map[string][]string, deps map[string]string, user bool) error {
 	klog.V(4).Infof("正向依赖检查: %v\n", deps)
 	for svc, constraint := range deps {
-		c, err := semver.NewConstraint(constraint)
+		// 能力由ResolveCapabilities检查
+		if _, ok := IsCapability(svc); ok {
+			continue
+		}
+		r, err := ParseRequirement(constraint)
 		if err != nil {
 			return err
 		}
+		desc := "依赖约束"
+		if user {
+			desc = UserDependenceAnnotation(svc) + " annotation声明的依赖约束"
+		}
 
 		obj := objs[svc]
 		if obj == nil {
-			klog.V(4).Info("被依赖的服务不存在: %s\n", svc)
+			if r.Required {
+				return fmt.Errorf("正向依赖检查失败，缺少%s(%s)要求的服务%s", desc, constraint, svc)
+			}
+			klog.V(4).Infof("被依赖的服务不存在: %s\n", svc)
 			continue
 		}
 
-		version, err := GetVersion(obj)
-		if version == "" {
+		if len(versions[svc]) == 0 {
 			klog.V(4).Infof("被依赖的服务版本为空: %s\n", svc)
 			continue
 		}
 
-		v, err := semver.NewVersion(version)
-		if err != nil {
-			return err
-		}
-		if !c.Check(v) {
-			return errors.New(fmt.Sprintf("正向依赖检查失败，%s版本(%s)不符合依赖约束(%s)", svc, version, constraint))
+		for _, version := range versions[svc] {
+			v, err := semver.NewVersion(version)
+			if err != nil {
+				return err
+			}
+			if conflict := r.Conflict(v); conflict != "" {
+				return fmt.Errorf("正向依赖检查失败，%s版本(%s)与%s(%s)冲突", svc, version, desc, constraint)
+			}
+			if !r.Matches(v) {
+				return errors.New(fmt.Sprintf("正向依赖检查失败，%s版本(%s)不符合%s(%s)", svc, version, desc, constraint))
+			}
 		}
 	}
 	return nil
 }
 
-func CheckReverseDependence(objs map[string]*v12.ObjectMeta, svc string, version string) error {
+func CheckReverseDependence(objs map[string]v12.Object, svc string, version string) error {
 	klog.V(4).Infof("反向依赖检查: %s %s\n", svc, version)
 	if version == "" {
 		return nil
@@ -114,27 +199,99 @@ func CheckReverseDependence(objs map[string]*v12.ObjectMeta, svc string, version
 	}
 
 	key := svc + K8sAnnotationDependence
+	userKey := svc + K8sAnnotationUserDependence
 	for _, obj := range objs {
-		depRaw := obj.GetAnnotations()[key]
-		if depRaw == "" {
-			continue
-		}
-		deps := strings.Split(depRaw, ",")
-		for _, dep := range deps {
-			c, err := semver.NewConstraint(dep)
+		for _, k := range []string{key, userKey} {
+			dep := obj.GetAnnotations()[k]
+			if dep == "" {
+				continue
+			}
+			desc := fmt.Sprintf("%s的依赖约束(%s)", obj.GetName(), dep)
+			if k == userKey {
+				desc = fmt.Sprintf("%s在%s annotation中声明的依赖约束(%s)", obj.GetName(), userKey, dep)
+			}
+			// 多个约束以","连接时为且, 以"||"连接时为或, 需整体解析
+			// 格式错误的约束只影响声明它的对象, 由FindMalformedConstraints报告, 此处跳过
+			r, err := ParseRequirement(dep)
 			if err != nil {
-				return err
+				klog.V(4).Infof("跳过格式错误的依赖约束: %s %s=%s\n", obj.GetName(), k, dep)
+				continue
 			}
-			if !c.Check(v) {
-				return errors.New(fmt.Sprintf("反向依赖检查失败，%s版本(%s)不符合%s的依赖约束(%s)", svc, version, obj.GetName(), dep))
+			if conflict := r.Conflict(v); conflict != "" {
+				return fmt.Errorf("反向依赖检查失败，%s版本(%s)与%s冲突", svc, version, desc)
+			}
+			if !r.Matches(v) {
+				return errors.New(fmt.Sprintf("反向依赖检查失败，%s版本(%s)不符合%s", svc, version, desc))
+			}
+		}
+	}
+	return nil
+}
+
+// MalformedConstraint 对象上格式错误的依赖约束
+type MalformedConstraint struct {
+	Object     v12.Object
+	Annotation string
+	Constraint string
+	Err        error
+}
+
+func (m MalformedConstraint) Error() string {
+	return fmt.Sprintf("%s的%s annotation依赖约束(%s)格式错误: %v", m.Object.GetName(), m.Annotation, m.Constraint, m.Err)
+}
+
+// FindMalformedConstraints 查找对象上对svc声明的格式错误的依赖约束, svc为空时查找所有服务
+func FindMalformedConstraints(objs map[string]v12.Object, svc string) []MalformedConstraint {
+	var results []MalformedConstraint
+	for _, obj := range objs {
+		results = append(results, objMalformedConstraints(obj, svc)...)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Object.GetName() != results[j].Object.GetName() {
+			return results[i].Object.GetName() < results[j].Object.GetName()
+		}
+		return results[i].Annotation < results[j].Annotation
+	})
+	return results
+}
+
+func objMalformedConstraints(obj v12.Object, svc string) []MalformedConstraint {
+	var results []MalformedConstraint
+	for k, dep := range obj.GetAnnotations() {
+		name := strings.TrimSuffix(k, K8sAnnotationDependence)
+		if name == k {
+			name = strings.TrimSuffix(k, K8sAnnotationUserDependence)
+		}
+		if name == k {
+			for _, suffix := range []string{K8sAnnotationCapabilityDependence, K8sAnnotationUserCapabilityDependence} {
+				if capability := strings.TrimSuffix(k, suffix); capability != k && capability != "" {
+					name = CapabilityPrefix + capability
+					break
+				}
 			}
 		}
+		if name == k || name == "" || (svc != "" && name != svc) {
+			continue
+		}
+		if _, err := ParseRequirement(dep); err != nil {
+			results = append(results, MalformedConstraint{Object: obj, Annotation: k, Constraint: dep, Err: err})
+		}
+	}
+	return results
+}
+
+// ValidateObjDependence 检查对象上声明的依赖约束格式是否正确, 返回第一个格式错误的约束
+func ValidateObjDependence(obj v12.Object) error {
+	malformed := FindMalformedConstraints(map[string]v12.Object{obj.GetName(): obj}, "")
+	if len(malformed) > 0 {
+		return malformed[0]
 	}
 	return nil
 }
 
-// SetObjVersion 设置对象的版本号
-func SetObjVersion(obj *v12.ObjectMeta, version string, deps map[string]string) {
+// SetObjVersion 设置对象的版本号和依赖约束
+// 依赖约束以deps为准, 不再依赖的服务的约束会被移除, 变更前的依赖约束记录在K8sAnnotationPreviousDependence中
+func SetObjVersion(obj v12.Object, version string, deps map[string]string) {
 	Labels := obj.GetLabels()
 	if Labels == nil {
 		Labels = map[string]string{}
@@ -142,36 +299,121 @@ func SetObjVersion(obj *v12.ObjectMeta, version string, deps map[string]string)
 	Labels[K8sLabelVersion] = version
 	obj.SetLabels(Labels)
 
+	previous := GetObjDependence(obj)
 	annotations := obj.GetAnnotations()
 	if annotations == nil {
 		annotations = map[string]string{}
 	}
+	for svc := range previous {
+		if _, ok := deps[svc]; !ok {
+			delete(annotations, dependenceAnnotation(svc))
+		}
+	}
 	for k, v := range deps {
-		annotations[k+K8sAnnotationDependence] = v
+		annotations[dependenceAnnotation(k)] = v
+	}
+	if len(previous) > 0 && !reflect.DeepEqual(previous, deps) {
+		raw, _ := json.Marshal(previous)
+		annotations[K8sAnnotationPreviousDependence] = string(raw)
 	}
 	obj.SetAnnotations(annotations)
 }
 
-func GetVersion(obj runtime.Object) (string, error) {
-	var spec corev1.PodTemplateSpec
-	var objN v12.ObjectMeta
-	switch obj.(type) {
-	case *appsv1.Deployment:
-		spec = obj.(*appsv1.Deployment).Spec.Template
-		objN = obj.(*appsv1.Deployment).ObjectMeta
-	case *appsv1.StatefulSet:
-		spec = obj.(*appsv1.StatefulSet).Spec.Template
-		objN = obj.(*appsv1.StatefulSet).ObjectMeta
-	case *appsv1.DaemonSet:
-		spec = obj.(*appsv1.DaemonSet).Spec.Template
-		objN = obj.(*appsv1.DaemonSet).ObjectMeta
+// SetObjDependenceSources 记录依赖约束由哪些容器声明, sources为空时移除记录
+func SetObjDependenceSources(obj v12.Object, sources map[string][]ConstraintSource) {
+	annotations := obj.GetAnnotations()
+	if len(sources) == 0 {
+		if _, ok := annotations[K8sAnnotationDependenceSources]; ok {
+			delete(annotations, K8sAnnotationDependenceSources)
+			obj.SetAnnotations(annotations)
+		}
+		return
 	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	raw, _ := json.Marshal(sources)
+	annotations[K8sAnnotationDependenceSources] = string(raw)
+	obj.SetAnnotations(annotations)
+}
 
-	version := objN.GetLabels()[K8sLabelVersion]
-	if version != "" {
-		return version, nil
+// GetObjDependenceSources 获取对象上记录的依赖约束来源, 记录格式错误时返回nil
+func GetObjDependenceSources(obj v12.Object) map[string][]ConstraintSource {
+	raw := obj.GetAnnotations()[K8sAnnotationDependenceSources]
+	if raw == "" {
+		return nil
 	}
+	var sources map[string][]ConstraintSource
+	if err := json.Unmarshal([]byte(raw), &sources); err != nil {
+		return nil
+	}
+	return sources
+}
+
+// GetObjDependence 获取对象上记录的依赖约束, 对能力的依赖约束以CapabilityPrefix为前缀
+func GetObjDependence(obj v12.Object) map[string]string {
+	deps := make(map[string]string)
+	for k, v := range obj.GetAnnotations() {
+		if svc := strings.TrimSuffix(k, K8sAnnotationDependence); svc != k && svc != "" {
+			deps[svc] = v
+		} else if capability := strings.TrimSuffix(k, K8sAnnotationCapabilityDependence); capability != k && capability != "" {
+			deps[CapabilityPrefix+capability] = v
+		}
+	}
+	return deps
+}
+
+// GetObjUserDependence 获取用户在对象上声明的依赖约束, 对能力的依赖约束以CapabilityPrefix为前缀
+func GetObjUserDependence(obj v12.Object) map[string]string {
+	deps := make(map[string]string)
+	for k, v := range obj.GetAnnotations() {
+		if svc := strings.TrimSuffix(k, K8sAnnotationUserDependence); svc != k && svc != "" {
+			deps[svc] = v
+		} else if capability := strings.TrimSuffix(k, K8sAnnotationUserCapabilityDependence); capability != k && capability != "" {
+			deps[CapabilityPrefix+capability] = v
+		}
+	}
+	return deps
+}
+
+// ValidateUserDependence 检查用户声明的依赖约束格式是否正确, 以及与镜像声明的依赖约束deps是否有交集
+func ValidateUserDependence(user, deps map[string]string) error {
+	merged := make(Dependences, len(deps))
+	for svc, constraint := range deps {
+		if err := merged.Add(svc, "镜像", constraint); err != nil {
+			return err
+		}
+	}
+	for svc, constraint := range user {
+		key := UserDependenceAnnotation(svc)
+		if _, err := ParseRequirement(constraint); err != nil {
+			return fmt.Errorf("%s annotation的依赖约束(%s)格式错误: %w", key, constraint, err)
+		}
+		if err := merged.Add(svc, key, constraint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return getVersionByPodTemplate(&spec), nil
+func GetVersion(obj runtime.Object) (string, error) {
+	workload, ok := GetWorkload(obj)
+	if !ok {
+		return "", nil
+	}
+	return workload.Version(), nil
+}
 
+// SameImages 判断两个Pod模板的镜像和平台是否相同
+// 版本和依赖约束只由镜像和平台决定, 相同时无需重新从镜像仓库获取
+func SameImages(a, b *corev1.PodTemplateSpec) bool {
+	images := func(podSpec *corev1.PodTemplateSpec) []string {
+		containers := podContainers(podSpec)
+		results := make([]string, 0, len(containers))
+		for _, c := range containers {
+			results = append(results, c.Name+"="+c.Image)
+		}
+		return results
+	}
+	return reflect.DeepEqual(images(a), images(b)) && reflect.DeepEqual(getPlatformByPodTemplate(a), getPlatformByPodTemplate(b))
 }
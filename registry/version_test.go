@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetObjVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		deps            map[string]string
+		wantAnnotations map[string]string
+	}{
+		{
+			name:        "new",
+			annotations: nil,
+			deps:        map[string]string{"ocm": "^2.0.0"},
+			wantAnnotations: map[string]string{
+				"ocm" + K8sAnnotationDependence: "^2.0.0",
+			},
+		},
+		{
+			name: "unchanged",
+			annotations: map[string]string{
+				"ocm" + K8sAnnotationDependence: "^2.0.0",
+				"owner":                         "wmc",
+			},
+			deps: map[string]string{"ocm": "^2.0.0"},
+			wantAnnotations: map[string]string{
+				"ocm" + K8sAnnotationDependence: "^2.0.0",
+				"owner":                         "wmc",
+			},
+		},
+		{
+			name: "dropped",
+			annotations: map[string]string{
+				"ocm" + K8sAnnotationDependence: "^2.0.0",
+				"cms" + K8sAnnotationDependence: "^4.0.0",
+				"owner":                         "wmc",
+			},
+			deps: map[string]string{"cms": "^4.1.0"},
+			wantAnnotations: map[string]string{
+				"cms" + K8sAnnotationDependence: "^4.1.0",
+				K8sAnnotationPreviousDependence: `{"cms":"^4.0.0","ocm":"^2.0.0"}`,
+				"owner":                         "wmc",
+			},
+		},
+		{
+			name: "dropped all",
+			annotations: map[string]string{
+				"ocm" + K8sAnnotationDependence: "^2.0.0",
+				K8sAnnotationPreviousDependence: `{"ocm":"^1.0.0"}`,
+			},
+			deps: map[string]string{},
+			wantAnnotations: map[string]string{
+				K8sAnnotationPreviousDependence: `{"ocm":"^2.0.0"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &v12.ObjectMeta{Annotations: tt.annotations}
+			SetObjVersion(obj, "1.8.1", tt.deps)
+			if got := obj.Labels[K8sLabelVersion]; got != "1.8.1" {
+				t.Errorf("SetObjVersion() version = %v, want %v", got, "1.8.1")
+			}
+			if !reflect.DeepEqual(obj.Annotations, tt.wantAnnotations) {
+				t.Errorf("SetObjVersion() annotations = %v, want %v", obj.Annotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
+
+func TestSetObjDependenceSources(t *testing.T) {
+	deps := make(Dependences)
+	for _, c := range []string{"wmc", "sidecar"} {
+		if err := deps.Add("ocm", c, "^2.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	obj := &v12.ObjectMeta{Annotations: map[string]string{"owner": "wmc"}}
+	SetObjDependenceSources(obj, deps.Sources())
+	want := map[string][]ConstraintSource{"ocm": {{Container: "wmc", Constraint: "^2.0.0"}, {Container: "sidecar", Constraint: "^2.0.0"}}}
+	if got := GetObjDependenceSources(obj); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetObjDependenceSources() = %v, want %v", got, want)
+	}
+
+	// 不再依赖任何服务时移除记录
+	SetObjDependenceSources(obj, make(Dependences).Sources())
+	if !reflect.DeepEqual(obj.Annotations, map[string]string{"owner": "wmc"}) {
+		t.Errorf("annotations = %v, want only owner", obj.Annotations)
+	}
+}
+
+func TestSameImages(t *testing.T) {
+	template := func(nodeSelector map[string]string, images ...string) *corev1.PodTemplateSpec {
+		podSpec := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{NodeSelector: nodeSelector}}
+		for i, image := range images {
+			podSpec.Spec.Containers = append(podSpec.Spec.Containers, corev1.Container{Name: fmt.Sprint("c", i), Image: image})
+		}
+		return podSpec
+	}
+	arm64 := map[string]string{corev1.LabelArchStable: "arm64"}
+	tests := []struct {
+		name string
+		a, b *corev1.PodTemplateSpec
+		want bool
+	}{
+		{name: "same", a: template(nil, "wmc:1.8.1", "sidecar:1.0.0"), b: template(map[string]string{"zone": "a"}, "wmc:1.8.1", "sidecar:1.0.0"), want: true},
+		{name: "image changed", a: template(nil, "wmc:1.8.1"), b: template(nil, "wmc:1.9.0")},
+		{name: "container added", a: template(nil, "wmc:1.8.1"), b: template(nil, "wmc:1.8.1", "sidecar:1.0.0")},
+		{name: "platform changed", a: template(nil, "wmc:1.8.1"), b: template(arm64, "wmc:1.8.1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameImages(tt.a, tt.b); got != tt.want {
+				t.Errorf("SameImages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUserDependence(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    map[string]string
+		deps    map[string]string
+		wantErr bool
+	}{
+		{name: "none", deps: map[string]string{"ocm": "^2.0.0"}},
+		{name: "narrower", user: map[string]string{"ocm": ">=2.3.0"}, deps: map[string]string{"ocm": "^2.0.0"}},
+		{name: "new service", user: map[string]string{"cms": "^4.0.0"}, deps: map[string]string{"ocm": "^2.0.0"}},
+		{name: "malformed", user: map[string]string{"ocm": "two"}, deps: map[string]string{"ocm": "^2.0.0"}, wantErr: true},
+		{name: "disjoint", user: map[string]string{"ocm": "^3.0.0"}, deps: map[string]string{"ocm": "^2.0.0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateUserDependence(tt.user, tt.deps); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUserDependence() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckReverseDependence_User(t *testing.T) {
+	wmc := &v12.ObjectMeta{Name: "wmc", Annotations: map[string]string{
+		"ocm" + K8sAnnotationUserDependence:               ">=2.3.0",
+		"ocm.api" + K8sAnnotationUserCapabilityDependence: "^2.0",
+	}}
+	// 用户声明的依赖约束不会被SetObjVersion修改
+	SetObjVersion(wmc, "1.8.1", map[string]string{"ocm": "^2.0.0"})
+	if got := GetObjUserDependence(wmc); !reflect.DeepEqual(got, map[string]string{"ocm": ">=2.3.0", "cap_ocm.api": "^2.0"}) {
+		t.Fatalf("GetObjUserDependence() = %v", got)
+	}
+	if got := GetObjDependence(wmc); !reflect.DeepEqual(got, map[string]string{"ocm": "^2.0.0"}) {
+		t.Fatalf("GetObjDependence() = %v", got)
+	}
+
+	objs := map[string]v12.Object{"wmc": wmc}
+	if err := CheckReverseDependence(objs, "ocm", "2.3.0"); err != nil {
+		t.Errorf("CheckReverseDependence(2.3.0) error = %v", err)
+	}
+	err := CheckReverseDependence(objs, "ocm", "2.2.0")
+	if err == nil || !strings.Contains(err.Error(), "ocm"+K8sAnnotationUserDependence) {
+		t.Errorf("CheckReverseDependence(2.2.0) error = %v, want error naming the annotation", err)
+	}
+}
+
+func TestFindMalformedConstraints(t *testing.T) {
+	objs := map[string]v12.Object{
+		"wmc": &v12.ObjectMeta{Name: "wmc", Annotations: map[string]string{
+			"ocm" + K8sAnnotationDependence:     "^2.0.0",
+			"cms" + K8sAnnotationUserDependence: "latest",
+		}},
+		"sms": &v12.ObjectMeta{Name: "sms", Annotations: map[string]string{
+			"ocm" + K8sAnnotationDependence: "2.x.y",
+			"owner":                         "sms",
+		}},
+	}
+
+	tests := []struct {
+		name string
+		svc  string
+		want []string
+	}{
+		{name: "all", want: []string{"sms/ocm" + K8sAnnotationDependence, "wmc/cms" + K8sAnnotationUserDependence}},
+		{name: "ocm", svc: "ocm", want: []string{"sms/ocm" + K8sAnnotationDependence}},
+		{name: "none", svc: "wmc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range FindMalformedConstraints(objs, tt.svc) {
+				got = append(got, m.Object.GetName()+"/"+m.Annotation)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindMalformedConstraints() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 格式错误的约束不影响其他服务的反向依赖检查
+	if err := CheckReverseDependence(objs, "ocm", "2.3.0"); err != nil {
+		t.Errorf("CheckReverseDependence() error = %v", err)
+	}
+	if err := ValidateObjDependence(objs["wmc"]); err == nil {
+		t.Error("ValidateObjDependence() error = nil, want malformed")
+	}
+}
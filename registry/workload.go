@@ -0,0 +1,164 @@
+package registry
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"sync"
+)
+
+// Workload 工作负载访问器
+// Meta和Template均指向原对象, 修改Meta直接作用于原对象;
+// 自定义工作负载的Template为解析出的副本, 修改后需调用Sync写回原对象
+type Workload struct {
+	// 内置工作负载的类型, 自定义工作负载为KRTUnknown, 以GVK区分
+	Type K8sResourceType
+	// 工作负载的GroupVersionKind
+	GVK      schema.GroupVersionKind
+	Meta     v12.Object
+	Template *corev1.PodTemplateSpec
+	sync     func() error
+}
+
+// WorkloadKind 自定义工作负载类型, 如Argo Rollouts、OpenKruise CloneSet
+type WorkloadKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+	// Pod模板在对象中的路径, 以"."分隔, 默认为spec.template
+	TemplatePath string `json:"templatePath,omitempty"`
+}
+
+func (k WorkloadKind) GroupVersionKind() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: k.Group, Version: k.Version, Kind: k.Kind}
+}
+
+func (k WorkloadKind) templateFields() []string {
+	if k.TemplatePath == "" {
+		return []string{"spec", "template"}
+	}
+	return strings.Split(k.TemplatePath, ".")
+}
+
+var (
+	workloadKindsMu sync.RWMutex
+	workloadKinds   = map[schema.GroupVersionKind]WorkloadKind{}
+)
+
+// RegisterWorkloadKind 注册自定义工作负载类型
+func RegisterWorkloadKind(kind WorkloadKind) {
+	workloadKindsMu.Lock()
+	defer workloadKindsMu.Unlock()
+	workloadKinds[kind.GroupVersionKind()] = kind
+}
+
+// WorkloadKinds 返回已注册的自定义工作负载类型
+func WorkloadKinds() []WorkloadKind {
+	workloadKindsMu.RLock()
+	defer workloadKindsMu.RUnlock()
+	results := make([]WorkloadKind, 0, len(workloadKinds))
+	for _, k := range workloadKinds {
+		results = append(results, k)
+	}
+	return results
+}
+
+// LoadWorkloadKinds 从配置文件中读取自定义工作负载类型, 格式如:
+//
+//	workloads:
+//	- group: argoproj.io
+//	  version: v1alpha1
+//	  kind: Rollout
+//	- group: apps.kruise.io
+//	  version: v1alpha1
+//	  kind: CloneSet
+//	  templatePath: spec.template
+func LoadWorkloadKinds(path string) ([]WorkloadKind, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Workloads []WorkloadKind `json:"workloads"`
+	}
+	if err = yaml.UnmarshalStrict(raw, &cfg); err != nil {
+		return nil, err
+	}
+	for _, k := range cfg.Workloads {
+		if k.Version == "" || k.Kind == "" {
+			return nil, fmt.Errorf("工作负载类型缺少version或kind: %+v", k)
+		}
+	}
+	return cfg.Workloads, nil
+}
+
+// GetWorkload 获取对象的工作负载访问器, 不支持的类型返回false
+func GetWorkload(obj runtime.Object) (*Workload, bool) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &Workload{Type: KRTDeployment, GVK: appsv1.SchemeGroupVersion.WithKind("Deployment"), Meta: &o.ObjectMeta, Template: &o.Spec.Template}, true
+	case *appsv1.StatefulSet:
+		return &Workload{Type: KRTStatefulSet, GVK: appsv1.SchemeGroupVersion.WithKind("StatefulSet"), Meta: &o.ObjectMeta, Template: &o.Spec.Template}, true
+	case *appsv1.DaemonSet:
+		return &Workload{Type: KRTDaemonSet, GVK: appsv1.SchemeGroupVersion.WithKind("DaemonSet"), Meta: &o.ObjectMeta, Template: &o.Spec.Template}, true
+	case *appsv1.ReplicaSet:
+		return &Workload{Type: KrtReplicaSet, GVK: appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), Meta: &o.ObjectMeta, Template: &o.Spec.Template}, true
+	case *unstructured.Unstructured:
+		return getUnstructuredWorkload(o)
+	}
+	return nil, false
+}
+
+func getUnstructuredWorkload(obj *unstructured.Unstructured) (*Workload, bool) {
+	workloadKindsMu.RLock()
+	kind, ok := workloadKinds[obj.GroupVersionKind()]
+	workloadKindsMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	fields := kind.templateFields()
+	template := &corev1.PodTemplateSpec{}
+	// Pod模板不存在(如引用了其他工作负载的Rollout)或格式错误时按空模板处理
+	if raw, found, err := unstructured.NestedMap(obj.Object, fields...); err == nil && found {
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(raw, template)
+	}
+	// 自定义工作负载的kind可能与内置类型同名(如Redis), 不按kind解析类型
+	return &Workload{
+		Type:     KRTUnknown,
+		GVK:      kind.GroupVersionKind(),
+		Meta:     obj,
+		Template: template,
+		sync: func() error {
+			raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(template)
+			if err != nil {
+				return err
+			}
+			return unstructured.SetNestedMap(obj.Object, raw, fields...)
+		},
+	}, true
+}
+
+// Sync 将Template的修改写回原对象
+func (w *Workload) Sync() error {
+	if w.sync == nil {
+		return nil
+	}
+	return w.sync()
+}
+
+// Version 获取工作负载的版本, 优先使用版本label, 其次从Pod模板中获取
+func (w *Workload) Version() string {
+	version := w.Meta.GetLabels()[K8sLabelVersion]
+	if version != "" {
+		return version
+	}
+	return getVersionByPodTemplate(w.Template)
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestRollout() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata":   map[string]interface{}{"name": "ocm", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "ocm", "image": "harbor:5000/wecloud/ocm:2.3.0"},
+					},
+				},
+			},
+		},
+	}}
+}
+
+func TestGetWorkload_Unstructured(t *testing.T) {
+	obj := newTestRollout()
+	if _, ok := GetWorkload(obj); ok {
+		t.Fatalf("GetWorkload() want unregistered kind to be unsupported")
+	}
+
+	RegisterWorkloadKind(WorkloadKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"})
+	workload, ok := GetWorkload(obj)
+	if !ok {
+		t.Fatalf("GetWorkload() want registered kind to be supported")
+	}
+	if want := (schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}); workload.GVK != want || workload.Type != KRTUnknown {
+		t.Errorf("GetWorkload() type = %v, gvk = %v, want %v", workload.Type, workload.GVK, want)
+	}
+	if got := workload.Version(); got != "2.3.0" {
+		t.Errorf("Version() got = %v, want %v", got, "2.3.0")
+	}
+
+	SetObjVersion(workload.Meta, "2.3.0", map[string]string{"cms": "^4.0.0"})
+	if got := obj.GetAnnotations()["cms"+K8sAnnotationDependence]; got != "^4.0.0" {
+		t.Errorf("SetObjVersion() annotation got = %v, want %v", got, "^4.0.0")
+	}
+
+	workload.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "A", Value: "1"}}
+	if err := workload.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+	env := containers[0].(map[string]interface{})["env"]
+	if !reflect.DeepEqual(env, []interface{}{map[string]interface{}{"name": "A", "value": "1"}}) {
+		t.Errorf("Sync() env got = %v", env)
+	}
+}
+
+func TestLoadWorkloadKinds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workloads.yaml")
+	raw := `workloads:
+- group: argoproj.io
+  version: v1alpha1
+  kind: Rollout
+- group: apps.kruise.io
+  version: v1alpha1
+  kind: CloneSet
+  templatePath: spec.template
+`
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadWorkloadKinds(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []WorkloadKind{
+		{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"},
+		{Group: "apps.kruise.io", Version: "v1alpha1", Kind: "CloneSet", TemplatePath: "spec.template"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadWorkloadKinds() got = %v, want %v", got, want)
+	}
+
+	if err = os.WriteFile(path, []byte("workloads:\n- group: argoproj.io\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadWorkloadKinds(path); err == nil {
+		t.Errorf("LoadWorkloadKinds() want error for missing kind")
+	}
+}
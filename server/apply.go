@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderSsid = "X-Ssid" // 调用方的会话ID, 对应UpdateRequest.Ssid
+
+	EventReasonApplied = "ImagesApplied"
+)
+
+// 将请求中的容器应用到集群中的对象上并提交, 由webhook执行依赖检查, 返回准入结果
+// 鉴权通过后以dictator自身的身份提交, 调用方记录在webhook.K8sAnnotationAppliedBy中,
+// webhook中的豁免、覆盖权限和版本历史均以调用方为准
+// Comment记录为change-cause, Ssid记录在对象的annotation、事件和审计日志中
+// 认证通过后的每个请求都记录审计日志, 包括参数错误、无权限和被拒绝的请求
+func (s *Server) apply(w http.ResponseWriter, r *http.Request) {
+	ssid := r.Header.Get(HeaderSsid)
+	user := userFromContext(r.Context())
+	sw := &statusWriter{ResponseWriter: w}
+	var req *updateRequest
+	var resp *CheckResponse
+	defer func() {
+		keysAndValues := []interface{}{"ssid", ssid, "user", user.Username, "code", sw.code}
+		if req != nil {
+			keysAndValues = append(keysAndValues, "namespace", req.Namespace, "name", req.Name,
+				"images", containerImages(req.Containers), "comment", req.Comment)
+		}
+		if resp != nil {
+			keysAndValues = append(keysAndValues, "allowed", resp.Allowed, "violations", resp.Violations, "warnings", resp.Warnings)
+		}
+		s.audit.Info("应用镜像", keysAndValues...)
+	}()
+
+	if ssid == "" {
+		writeError(sw, http.StatusBadRequest, "缺少"+HeaderSsid)
+		return
+	}
+	req, ok := s.loadUpdateRequest(sw, r, "patch")
+	if !ok {
+		return
+	}
+	req.Ssid = ssid
+
+	annotations := req.newObj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[registry.K8sAnnotationSsid] = req.Ssid
+	if req.Comment != "" {
+		annotations[registry.K8sAnnotationChangeCause] = req.Comment
+	} else {
+		delete(annotations, registry.K8sAnnotationChangeCause)
+	}
+	req.newObj.SetAnnotations(annotations)
+	if err := webhook.SetAppliedBy(req.newObj, req.user, time.Now()); err != nil {
+		writeError(sw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	req.logger.Info("收到应用请求", "ssid", req.Ssid)
+	warnings := &warningCollector{}
+	submitClient, err := s.submit(warnings)
+	if err != nil {
+		req.logger.Error(err, "创建客户端失败", "ssid", req.Ssid)
+		writeError(sw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	patch := client.MergeFromWithOptions(req.oldObj, client.MergeFromWithOptimisticLock{})
+	err = submitClient.Patch(r.Context(), req.newObj, patch)
+	resp = &CheckResponse{Allowed: true, Warnings: warnings.messages}
+	var apiStatus apierrors.APIStatus
+	switch {
+	case err == nil:
+	case apierrors.IsConflict(err):
+		writeError(sw, http.StatusConflict, "对象已被修改, 请重试: "+err.Error())
+		return
+	case errors.As(err, &apiStatus) && apiStatus.Status().Code >= 400 && apiStatus.Status().Code < 500:
+		// 被webhook或API Server拒绝, 如依赖检查未通过(403)、依赖未就绪(429)、约束格式错误
+		resp.Allowed = false
+		resp.Violations = []string{err.Error()}
+	default:
+		req.logger.Error(err, "应用失败", "ssid", req.Ssid)
+		writeError(sw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !resp.Allowed {
+		code := http.StatusOK
+		// 依赖未就绪等可重试的拒绝, 保留webhook建议的重试间隔
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok {
+			sw.Header().Set("Retry-After", strconv.Itoa(seconds))
+			code = http.StatusTooManyRequests
+		}
+		writeJSON(sw, code, resp)
+		return
+	}
+
+	if s.recorder != nil {
+		s.recorder.Eventf(req.newObj, corev1.EventTypeNormal, EventReasonApplied, "%s更新镜像%s, 会话: %s, 描述: %s",
+			req.user.Username, strings.Join(containerImages(req.Containers), ", "), req.Ssid, req.Comment)
+	}
+	// Patch后newObj为经webhook处理后存储的对象
+	resp.Version = req.newObj.GetLabels()[registry.K8sLabelVersion]
+	resp.Dependences = registry.GetObjDependence(req.newObj)
+	writeJSON(sw, http.StatusOK, resp)
+}
+
+// 请求中的容器和镜像, 格式为 容器名=镜像
+func containerImages(containers []registry.Container) []string {
+	images := make([]string, len(containers))
+	for i, c := range containers {
+		images[i] = c.Name + "=" + c.Image
+	}
+	return images
+}
+
+// 记录响应状态码, 用于审计日志
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
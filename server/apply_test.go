@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+func TestServer_apply(t *testing.T) {
+	const image = "harbor:5000/wecloud/wmc:1.8.2"
+	body := `{"name":"wmc","comment":"修复登录","resourceType":1,"containers":[{"name":"wmc","type":2,"image":"` + image + `","env":[{"name":"MODE","value":"prod"}]}]}`
+	denied := apierrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, "wmc",
+		errors.New(`admission webhook "vdeployment.kb.io" denied the request: ocm版本2.3.0不满足依赖约束^3.0.0`))
+
+	notReady := apierrors.NewTooManyRequests(`admission webhook "vdeployment.kb.io" denied the request: 依赖就绪检查未通过`, 10)
+	malformed := apierrors.NewBadRequest(`admission webhook "mdeployment.kb.io" denied the request: 依赖约束格式错误`)
+
+	tests := []struct {
+		name          string
+		ssid          string
+		allowed       bool
+		patchErr      error
+		wantCode      int
+		wantAllowed   bool
+		wantViolation string
+		wantRetry     string
+		wantImage     string
+	}{
+		{name: "missing ssid", allowed: true, wantCode: http.StatusBadRequest, wantImage: "harbor:5000/wecloud/wmc:1.8.1"},
+		{name: "forbidden", ssid: "s-1", wantCode: http.StatusForbidden, wantImage: "harbor:5000/wecloud/wmc:1.8.1"},
+		{name: "denied", ssid: "s-1", allowed: true, patchErr: denied, wantCode: http.StatusOK, wantViolation: "不满足依赖约束", wantImage: "harbor:5000/wecloud/wmc:1.8.1"},
+		{name: "not ready", ssid: "s-1", allowed: true, patchErr: notReady, wantCode: http.StatusTooManyRequests, wantViolation: "依赖就绪检查未通过",
+			wantRetry: "10", wantImage: "harbor:5000/wecloud/wmc:1.8.1"},
+		{name: "generic denial", ssid: "s-1", allowed: true, patchErr: malformed, wantCode: http.StatusOK, wantViolation: "依赖约束格式错误",
+			wantImage: "harbor:5000/wecloud/wmc:1.8.1"},
+		{name: "conflict", ssid: "s-1", allowed: true, patchErr: apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "wmc", errors.New("changed")),
+			wantCode: http.StatusConflict, wantImage: "harbor:5000/wecloud/wmc:1.8.1"},
+		{name: "server error", ssid: "s-1", allowed: true, patchErr: apierrors.NewInternalError(errors.New("etcd unavailable")),
+			wantCode: http.StatusInternalServerError, wantImage: "harbor:5000/wecloud/wmc:1.8.1"},
+		{name: "applied", ssid: "s-1", allowed: true, wantCode: http.StatusOK, wantAllowed: true, wantImage: image},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", map[string]string{"ocm": "^2.0.0"})
+			old.Annotations[registry.K8sAnnotationChangeCause] = "上线"
+			c := reviewClient{Client: fake.NewClientBuilder().WithObjects(old).Build(), allowed: tt.allowed, patchErr: tt.patchErr}
+			recorder := record.NewFakeRecorder(1)
+			submit := func(warnings rest.WarningHandler) (client.Client, error) {
+				// 模拟webhook在准入响应中返回的警告
+				warnings.HandleWarningHeader(299, "", "已忽略格式错误的依赖约束")
+				return c, nil
+			}
+			var audits []string
+			audit := funcr.New(func(prefix, args string) { audits = append(audits, args) }, funcr.Options{})
+			s := &Server{client: c, logger: logr.Discard(), recorder: recorder, audit: audit, submit: submit}
+
+			r := httptest.NewRequest(http.MethodPost, PathApply, bytes.NewBufferString(body))
+			r.Header.Set("Authorization", "Bearer valid")
+			if tt.ssid != "" {
+				r.Header.Set(HeaderSsid, tt.ssid)
+			}
+			w := httptest.NewRecorder()
+			s.authenticate(http.HandlerFunc(s.apply)).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("apply() code = %d, want %d, body %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("apply() Retry-After = %q, want %q", got, tt.wantRetry)
+			}
+			// 认证通过后的每个请求都记录审计日志
+			wantAudit := fmt.Sprintf(`"code"=%d`, tt.wantCode)
+			if len(audits) != 1 || !strings.Contains(audits[0], wantAudit) {
+				t.Errorf("apply() audits = %v, want one with %s", audits, wantAudit)
+			}
+
+			var got appsv1.Deployment
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(old), &got); err != nil {
+				t.Fatal(err)
+			}
+			container := got.Spec.Template.Spec.Containers[0]
+			if container.Image != tt.wantImage {
+				t.Errorf("apply() image = %q, want %q", container.Image, tt.wantImage)
+			}
+			if tt.wantCode != http.StatusOK && tt.wantCode != http.StatusTooManyRequests {
+				return
+			}
+
+			var resp CheckResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("apply() allowed = %v, want %v", resp.Allowed, tt.wantAllowed)
+			}
+			if !tt.wantAllowed {
+				if len(resp.Violations) != 1 || !strings.Contains(resp.Violations[0], tt.wantViolation) {
+					t.Errorf("apply() violations = %v", resp.Violations)
+				}
+				return
+			}
+
+			if len(container.Env) != 1 || container.Env[0].Value != "prod" {
+				t.Errorf("apply() env = %v", container.Env)
+			}
+			if v := got.Annotations[registry.K8sAnnotationChangeCause]; v != "修复登录" {
+				t.Errorf("apply() change-cause = %q, want %q", v, "修复登录")
+			}
+			if v := got.Annotations[registry.K8sAnnotationSsid]; v != tt.ssid {
+				t.Errorf("apply() ssid = %q, want %q", v, tt.ssid)
+			}
+			// 以dictator的身份提交, 调用方记录在annotation中
+			var appliedBy webhook.AppliedBy
+			if err := json.Unmarshal([]byte(got.Annotations[webhook.K8sAnnotationAppliedBy]), &appliedBy); err != nil || appliedBy.User.Username != "deployer" {
+				t.Errorf("apply() applied-by = %q, err %v", got.Annotations[webhook.K8sAnnotationAppliedBy], err)
+			}
+			if resp.Version != "1.8.1" || resp.Dependences["ocm"] != "^2.0.0" {
+				t.Errorf("apply() version = %q, dependences = %v", resp.Version, resp.Dependences)
+			}
+			if !reflect.DeepEqual(resp.Warnings, []string{"已忽略格式错误的依赖约束"}) {
+				t.Errorf("apply() warnings = %v", resp.Warnings)
+			}
+			select {
+			case e := <-recorder.Events:
+				if !strings.Contains(e, EventReasonApplied) || !strings.Contains(e, tt.ssid) {
+					t.Errorf("apply() event = %q", e)
+				}
+			default:
+				t.Error("apply()未记录事件")
+			}
+		})
+	}
+}
+
+func TestWarningClient(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Warning", `299 - "已忽略格式错误的依赖约束"`)
+		_ = json.NewEncoder(w).Encode(testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.2", "1.8.2", nil))
+	}))
+	defer ts.Close()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	warnings := &warningCollector{}
+	c, err := warningClient(&rest.Config{Host: ts.URL}, client.Options{Scheme: scheme, Mapper: mapper})(warnings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.2", "1.8.2", nil)
+	if err = c.Patch(context.Background(), obj, client.RawPatch(types.MergePatchType, []byte(`{}`))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 以dictator自身的身份提交, 不模拟其他用户
+	for k := range got {
+		if strings.HasPrefix(k, "Impersonate-") {
+			t.Errorf("unexpected header %s = %v", k, got.Values(k))
+		}
+	}
+	// 准入警告返回给调用方
+	if !reflect.DeepEqual(warnings.messages, []string{"已忽略格式错误的依赖约束"}) {
+		t.Errorf("warnings = %v", warnings.messages)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	"net/http"
+)
+
+// HistoryResponse 版本历史查询结果, 按时间倒序排列
+type HistoryResponse struct {
+	Items []webhook.HistoryRecord `json:"items"`
+}
+
+// 查询命名空间下服务的版本历史, 参数namespace默认为default, service为空时返回所有服务
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "仅支持GET")
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+	svc := r.URL.Query().Get("service")
+
+	ctx := r.Context()
+	user := userFromContext(ctx)
+	verb := "list"
+	if svc != "" {
+		verb = "get"
+	}
+	gvr := wkmv1alpha1.GroupVersion.WithResource("versionhistories")
+	allowed, err := s.authorize(ctx, user, verb, gvr, namespace, svc)
+	if err != nil {
+		s.logger.Error(err, "鉴权失败")
+		writeError(w, http.StatusInternalServerError, "鉴权失败: "+err.Error())
+		return
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("%s无权%s %s下的版本历史", user.Username, verb, namespace))
+		return
+	}
+
+	records, err := webhook.ListHistory(ctx, s.client, namespace, svc)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if records == nil {
+		records = []webhook.HistoryRecord{}
+	}
+	writeJSON(w, http.StatusOK, HistoryResponse{Items: records})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/go-logr/logr"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+	"time"
+)
+
+func TestServer_history(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := wkmv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// 第i条记录的时间为start+i分钟
+	newHistory := func(svc string, start time.Time, versions ...string) *wkmv1alpha1.VersionHistory {
+		h := &wkmv1alpha1.VersionHistory{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: svc}}
+		for i, v := range versions {
+			h.Spec.Entries = append(h.Spec.Entries, wkmv1alpha1.VersionHistoryEntry{
+				Time: metav1.NewTime(start.Add(time.Duration(i) * time.Minute)), Kind: "Deployment", NewVersion: v,
+			})
+		}
+		return h
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		allowed  bool
+		wantCode int
+		want     []string
+	}{
+		{name: "forbidden", query: "?namespace=default", wantCode: http.StatusForbidden},
+		{name: "all services", query: "", allowed: true, wantCode: http.StatusOK, want: []string{"ocm=2.3.0", "wmc=1.8.1", "ocm=2.2.1", "wmc=1.8.0", "ocm=2.2.0"}},
+		{name: "one service", query: "?service=wmc", allowed: true, wantCode: http.StatusOK, want: []string{"wmc=1.8.1", "wmc=1.8.0"}},
+		{name: "no history", query: "?service=cms", allowed: true, wantCode: http.StatusOK, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(newHistory("wmc", now.Add(30*time.Second), "1.8.0", "1.8.1"), newHistory("ocm", now, "2.2.0", "2.2.1", "2.3.0")).Build()
+			s := &Server{client: reviewClient{Client: c, allowed: tt.allowed}, logger: logr.Discard()}
+
+			r := httptest.NewRequest(http.MethodGet, PathHistory+tt.query, nil)
+			r.Header.Set("Authorization", "Bearer valid")
+			w := httptest.NewRecorder()
+			s.authenticate(http.HandlerFunc(s.history)).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("history() code = %d, want %d, body %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp HistoryResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(resp.Items))
+			for _, r := range resp.Items {
+				got = append(got, r.Service+"="+r.NewVersion)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("history() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
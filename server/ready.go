@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ReadyResponse 服务依赖的服务是否都有运行符合约束版本的就绪副本
+type ReadyResponse struct {
+	Ready bool `json:"ready"`
+	// 未就绪的原因
+	Message string `json:"message,omitempty"`
+}
+
+// 查询服务依赖的服务是否就绪, 参数namespace默认为default, name为服务名称
+// 服务自身的ServiceAccount可以直接查询, 以便等待依赖的init容器使用Pod自身的Token, 其他用户需要dependencystatuses的get权限
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "仅支持GET")
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "缺少服务名称")
+		return
+	}
+
+	ctx := r.Context()
+	user := userFromContext(ctx)
+	if user.Username == "" {
+		writeError(w, http.StatusUnauthorized, "缺少Bearer Token")
+		return
+	}
+	if !s.isWorkloadServiceAccount(ctx, user, namespace, name) {
+		gvr := wkmv1alpha1.GroupVersion.WithResource("dependencystatuses")
+		allowed, err := s.authorize(ctx, user, "get", gvr, namespace, "")
+		if err != nil {
+			s.logger.Error(err, "鉴权失败")
+			writeError(w, http.StatusInternalServerError, "鉴权失败: "+err.Error())
+			return
+		}
+		if !allowed {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s无权查询%s下服务的依赖状态", user.Username, namespace))
+			return
+		}
+	}
+
+	err := webhook.CheckDependenciesReady(ctx, s.client, s.logger, namespace, name)
+	switch {
+	case apierrors.IsNotFound(err):
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s/%s不存在", namespace, name))
+	case err != nil:
+		writeJSON(w, http.StatusOK, ReadyResponse{Message: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, ReadyResponse{Ready: true})
+	}
+}
+
+// 用户是否为服务Pod使用的ServiceAccount, 同一命名空间的其他ServiceAccount仍需鉴权
+// 服务不存在时返回false, 避免未授权的用户通过返回码判断服务是否存在
+func (s *Server) isWorkloadServiceAccount(ctx context.Context, user authenticationv1.UserInfo, namespace, name string) bool {
+	if !strings.HasPrefix(user.Username, "system:serviceaccount:"+namespace+":") {
+		return false
+	}
+	objsMap, err := webhook.ListWorkloads(ctx, s.client, s.logger, namespace)
+	if err != nil {
+		return false
+	}
+	workload, ok := registry.GetWorkload(objsMap[name])
+	if !ok {
+		return false
+	}
+	sa := workload.Template.Spec.ServiceAccountName
+	if sa == "" {
+		sa = "default"
+	}
+	return user.Username == "system:serviceaccount:"+namespace+":"+sa
+}
+
+// WaitForDependencies 定期查询dictator, 直到服务依赖的服务都已就绪或ctx结束, 用于等待依赖的init容器
+// ca为校验dictator服务证书的CA, 为空时使用系统CA
+func WaitForDependencies(ctx context.Context, logger logr.Logger, endpoint, namespace, name, token string, ca []byte, interval time.Duration) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return errors.New("无法解析CA证书")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	httpClient := &http.Client{Transport: transport, Timeout: interval}
+	query := url.Values{"namespace": {namespace}, "name": {name}}
+	target := strings.TrimSuffix(endpoint, "/") + PathReady + "?" + query.Encode()
+
+	var last string
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ready, message, err := queryReady(ctx, httpClient, target, token)
+		if ctx.Err() != nil {
+			// 超时中断的查询不覆盖上一次的结果
+			return fmt.Errorf("等待依赖的服务就绪超时: %s", last)
+		}
+		switch {
+		case err != nil:
+			last = err.Error()
+			logger.Info("查询依赖状态失败", "err", err)
+		case ready:
+			logger.Info("依赖的服务均已就绪")
+			return nil
+		default:
+			last = message
+			logger.Info("等待依赖的服务就绪", "message", message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("等待依赖的服务就绪超时: %s", last)
+		case <-ticker.C:
+		}
+	}
+}
+
+func queryReady(ctx context.Context, httpClient *http.Client, target, token string) (bool, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false, "", err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body errorResponse
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return false, "", fmt.Errorf("%s: %s", resp.Status, body.Message)
+	}
+	var body ReadyResponse
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, "", err
+	}
+	return body.Ready, body.Message, nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_ready(t *testing.T) {
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "2.3.0", nil)
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", map[string]string{"cms": "^4.0.0"})
+	wmc.Spec.Template.Spec.ServiceAccountName = "wmc"
+	ccs := testutil.NewDeployment("ccs", "harbor:5000/wecloud/ccs:1.2.0", "1.2.0", map[string]string{"ocm": "^2.0.0"})
+	c := fake.NewClientBuilder().WithObjects(ocm, wmc, ccs).Build()
+
+	tests := []struct {
+		name      string
+		token     string
+		query     string
+		allowed   bool
+		wantCode  int
+		wantReady bool
+	}{
+		{name: "missing token", query: "?name=wmc", allowed: true, wantCode: http.StatusUnauthorized},
+		{name: "forbidden", token: "valid", query: "?name=wmc", wantCode: http.StatusForbidden},
+		{name: "missing name", token: "valid", query: "", allowed: true, wantCode: http.StatusBadRequest},
+		{name: "not found", token: "valid", query: "?name=cms", allowed: true, wantCode: http.StatusNotFound},
+		// cms不存在且不是required
+		{name: "ready", token: "valid", query: "?name=wmc", allowed: true, wantCode: http.StatusOK, wantReady: true},
+		// ocm没有就绪的Pod
+		{name: "not ready", token: "valid", query: "?name=ccs", allowed: true, wantCode: http.StatusOK},
+		// 服务自身的ServiceAccount无需鉴权
+		{name: "own service account", token: "system:serviceaccount:default:wmc", query: "?name=wmc", wantCode: http.StatusOK, wantReady: true},
+		{name: "default service account", token: "system:serviceaccount:default:default", query: "?name=ccs", wantCode: http.StatusOK},
+		// 同一命名空间的其他ServiceAccount需要鉴权
+		{name: "other service account", token: "system:serviceaccount:default:default", query: "?name=wmc", wantCode: http.StatusForbidden},
+		{name: "service account of missing service", token: "system:serviceaccount:default:cms", query: "?name=cms", wantCode: http.StatusForbidden},
+		{name: "other namespace", token: "system:serviceaccount:kube-system:wmc", query: "?name=wmc", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{client: reviewClient{Client: c, allowed: tt.allowed}, logger: logr.Discard()}
+			r := httptest.NewRequest(http.MethodGet, PathReady+tt.query, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.authenticate(http.HandlerFunc(s.ready)).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("ready() code = %d, want %d, body %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp ReadyResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Ready != tt.wantReady || (!resp.Ready && resp.Message == "") {
+				t.Errorf("ready() = %+v, want ready %v", resp, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestWaitForDependencies(t *testing.T) {
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "2.3.0", nil)
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "1.8.1", nil)
+	ccs := testutil.NewDeployment("ccs", "harbor:5000/wecloud/ccs:1.2.0", "1.2.0", map[string]string{"ocm": "^2.0.0"})
+	c := fake.NewClientBuilder().WithObjects(ocm, wmc, ccs).Build()
+	s := &Server{client: reviewClient{Client: c, allowed: true}, logger: logr.Discard()}
+	mux := http.NewServeMux()
+	mux.Handle(PathReady, s.authenticate(http.HandlerFunc(s.ready)))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		svc     string
+		token   string
+		wantErr string
+	}{
+		{name: "ready", svc: "wmc", token: "valid"},
+		{name: "timeout", svc: "ccs", token: "valid", wantErr: "ocm"},
+		{name: "unauthorized", svc: "wmc", wantErr: "401"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := WaitForDependencies(ctx, logr.Discard(), ts.URL, "default", tt.svc, tt.token, nil, 20*time.Millisecond)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("WaitForDependencies() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("WaitForDependencies() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
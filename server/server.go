@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"net/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+const (
+	PathWhatIf  = "/api/v1/what-if"
+	PathApply   = "/api/v1/apply"
+	PathHistory = "/api/v1/history"
+	PathReady   = "/api/v1/ready"
+)
+
+// Server dictator的HTTP API, 注册在webhook服务上, 与webhook共用端口和证书
+// 请求需携带Kubernetes的Bearer Token, 由TokenReview认证, 由SubjectAccessReview鉴权
+type Server struct {
+	client   client.Client
+	logger   logr.Logger
+	recorder record.EventRecorder
+	// 记录应用请求的审计日志
+	audit logr.Logger
+	// 检查变更时与webhook使用相同的配置
+	options webhook.Options
+	// 以dictator自身的身份提交变更的client, 调用方记录在webhook.K8sAnnotationAppliedBy中
+	// warnings收集API Server返回的警告, 包括webhook的准入警告
+	submit func(warnings rest.WarningHandler) (client.Client, error)
+}
+
+func SetupServerWithManager(mgr ctrl.Manager, options webhook.Options) {
+	s := &Server{
+		client:   mgr.GetClient(),
+		logger:   logf.Log.WithName("[server]"),
+		audit:    logf.Log.WithName("[audit]"),
+		recorder: mgr.GetEventRecorderFor("dictator"),
+		options:  options,
+		submit:   warningClient(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()}),
+	}
+	hookServer := mgr.GetWebhookServer()
+	hookServer.Register(PathWhatIf, s.authenticate(http.HandlerFunc(s.whatIf)))
+	hookServer.Register(PathApply, s.authenticate(http.HandlerFunc(s.apply)))
+	hookServer.Register(PathHistory, s.authenticate(http.HandlerFunc(s.history)))
+	hookServer.Register(PathReady, s.authenticate(http.HandlerFunc(s.ready)))
+}
+
+type userKey struct{}
+
+// 对请求进行认证, 认证通过后将用户信息存入context
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			writeError(w, http.StatusUnauthorized, "缺少Bearer Token")
+			return
+		}
+		review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+		if err := s.client.Create(r.Context(), review); err != nil {
+			s.logger.Error(err, "认证失败")
+			writeError(w, http.StatusInternalServerError, "认证失败: "+err.Error())
+			return
+		}
+		if !review.Status.Authenticated {
+			writeError(w, http.StatusUnauthorized, "Token无效")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, review.Status.User)))
+	})
+}
+
+// 获取已认证的用户信息
+func userFromContext(ctx context.Context) authenticationv1.UserInfo {
+	user, _ := ctx.Value(userKey{}).(authenticationv1.UserInfo)
+	return user
+}
+
+// 返回将API Server的警告交给warnings处理的client
+// 不使用impersonate, 避免dictator拥有模拟任意用户(包括system:masters)的权限
+func warningClient(cfg *rest.Config, options client.Options) func(warnings rest.WarningHandler) (client.Client, error) {
+	options.Opts.SuppressWarnings = true
+	return func(warnings rest.WarningHandler) (client.Client, error) {
+		cfg := rest.CopyConfig(cfg)
+		cfg.WarningHandler = warnings
+		return client.New(cfg, options)
+	}
+}
+
+// 收集API Server返回的警告
+type warningCollector struct {
+	messages []string
+}
+
+func (c *warningCollector) HandleWarningHeader(code int, agent string, message string) {
+	if code == 299 && message != "" {
+		c.messages = append(c.messages, message)
+	}
+}
+
+// 检查用户是否有权限对资源执行verb
+func (s *Server) authorize(ctx context.Context, user authenticationv1.UserInfo, verb string, gvr schema.GroupVersionResource, namespace, name string) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Group:     gvr.Group,
+				Version:   gvr.Version,
+				Resource:  gvr.Resource,
+				Name:      name,
+			},
+		},
+	}
+	if err := s.client.Create(ctx, review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, errorResponse{Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
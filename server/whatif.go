@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CheckResponse 依赖检查的结果
+type CheckResponse struct {
+	Allowed bool `json:"allowed"`
+	// 更新后的版本
+	Version string `json:"version"`
+	// 更新后的依赖约束
+	Dependences map[string]string `json:"dependences"`
+	// 违反的依赖约束或策略
+	Violations []string `json:"violations,omitempty"`
+	// 不影响结果的警告, 如其他服务上格式错误的依赖约束
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// 已解析并通过鉴权的更新请求
+type updateRequest struct {
+	registry.UpdateRequest
+	user   authenticationv1.UserInfo
+	logger logr.Logger
+	// 集群中的对象
+	oldObj client.Object
+	// 应用了请求中容器的副本
+	newObj   client.Object
+	workload *registry.Workload
+}
+
+// 解析请求, 检查用户对目标对象是否有verb权限, 并将请求中的容器应用到对象的副本上
+// 失败时已写入响应并返回false, 请求已解析时仍返回解析的请求, 用于记录审计日志
+func (s *Server) loadUpdateRequest(w http.ResponseWriter, r *http.Request, verb string) (*updateRequest, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "仅支持POST")
+		return nil, false
+	}
+	req := &updateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req.UpdateRequest); err != nil {
+		writeError(w, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return nil, false
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	ctx := r.Context()
+	req.user = userFromContext(ctx)
+	allowed, err := s.authorize(ctx, req.user, verb, req.ResourceType.GVR(), req.Namespace, req.Name)
+	if err != nil {
+		s.logger.Error(err, "鉴权失败")
+		writeError(w, http.StatusInternalServerError, "鉴权失败: "+err.Error())
+		return req, false
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("%s无权%s %s/%s", req.user.Username, verb, req.Namespace, req.Name))
+		return req, false
+	}
+
+	if req.oldObj, err = newWorkloadObject(req.ResourceType); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	if err = s.client.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Name}, req.oldObj); err != nil {
+		if apierrors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s/%s不存在", req.ResourceType, req.Namespace, req.Name))
+			return req, false
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return req, false
+	}
+
+	req.newObj = req.oldObj.DeepCopyObject().(client.Object)
+	req.workload, _ = registry.GetWorkload(req.newObj)
+	if err = registry.ApplyContainers(req.workload.Template, req.Containers); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	req.logger = s.logger.WithValues("namespace", req.Namespace, "name", req.Name, "user", req.user.Username)
+	return req, true
+}
+
+// 将请求中的容器应用到集群中的对象上, 在内存中执行与webhook相同的版本推导和依赖检查, 不修改对象
+func (s *Server) whatIf(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.loadUpdateRequest(w, r, "get")
+	if !ok {
+		return
+	}
+
+	req.logger.Info("收到试运行请求")
+	warnings, err := webhook.DryRun(r.Context(), s.client, req.logger, s.options, req.user, req.oldObj, req.newObj)
+	resp := CheckResponse{Allowed: err == nil, Warnings: warnings}
+	if err != nil {
+		resp.Violations = []string{err.Error()}
+	}
+	resp.Version = req.workload.Version()
+	resp.Dependences = registry.GetObjDependence(req.workload.Meta)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// 创建资源类型对应的空对象, 仅支持Deployment、StatefulSet和DaemonSet
+func newWorkloadObject(resourceType registry.K8sResourceType) (client.Object, error) {
+	switch resourceType {
+	case registry.KRTDeployment:
+		return &appsv1.Deployment{}, nil
+	case registry.KRTStatefulSet:
+		return &appsv1.StatefulSet{}, nil
+	case registry.KRTDaemonSet:
+		return &appsv1.DaemonSet{}, nil
+	}
+	return nil, fmt.Errorf("不支持的资源类型%s", resourceType)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+// 只认可token "valid"和ServiceAccount用户名形式的token, 按allowed回复SubjectAccessReview, patchErr不为空时Patch返回该错误的client
+type reviewClient struct {
+	client.Client
+	allowed  bool
+	patchErr error
+}
+
+func (c reviewClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if c.patchErr != nil {
+		return c.patchErr
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	switch review := obj.(type) {
+	case *authenticationv1.TokenReview:
+		review.Status.Authenticated = review.Spec.Token == "valid"
+		review.Status.User = authenticationv1.UserInfo{Username: "deployer"}
+		// ServiceAccount的Token直接使用用户名
+		if strings.HasPrefix(review.Spec.Token, "system:serviceaccount:") {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: review.Spec.Token}
+		}
+		return nil
+	case *authorizationv1.SubjectAccessReview:
+		review.Status.Allowed = c.allowed && review.Spec.User == "deployer"
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestServer_whatIf(t *testing.T) {
+	host := testutil.NewRegistry(t)
+	wmc := testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^2.0.0"})
+	wmcPatch := testutil.PushImage(t, host, "wecloud/wmc:1.8.2", map[string]string{"ver_ocm": "^2.0.0"})
+	wmcNext := testutil.PushImage(t, host, "wecloud/wmc:1.9.0", map[string]string{"ver_ocm": "^3.0.0"})
+
+	tests := []struct {
+		name         string
+		token        string
+		allowed      bool
+		body         string
+		wantCode     int
+		wantAllowed  bool
+		wantVersion  string
+		wantWarnings int
+	}{
+		{name: "no token", body: `{}`, wantCode: http.StatusUnauthorized},
+		{name: "invalid token", token: "invalid", body: `{}`, wantCode: http.StatusUnauthorized},
+		{name: "bad request", token: "valid", allowed: true, body: `{"name":"wmc"}`, wantCode: http.StatusBadRequest},
+		{name: "forbidden", token: "valid", body: `{"name":"wmc","resourceType":1,"containers":[{"name":"wmc","type":2,"image":"` + wmcPatch + `"}]}`,
+			wantCode: http.StatusForbidden},
+		{name: "not found", token: "valid", allowed: true, body: `{"name":"cms","resourceType":1,"containers":[{"name":"cms","type":2,"image":"` + wmcPatch + `"}]}`,
+			wantCode: http.StatusNotFound},
+		{name: "unsupported type", token: "valid", allowed: true, body: `{"name":"wmc","resourceType":14,"containers":[{"name":"wmc","type":2,"image":"` + wmcPatch + `"}]}`,
+			wantCode: http.StatusBadRequest},
+		{name: "missing container", token: "valid", allowed: true, body: `{"name":"wmc","resourceType":1,"containers":[{"name":"sidecar","type":2,"image":"` + wmcPatch + `"}]}`,
+			wantCode: http.StatusBadRequest},
+		{name: "satisfied", token: "valid", allowed: true, body: `{"name":"wmc","resourceType":1,"containers":[{"name":"wmc","type":2,"image":"` + wmcPatch + `"}]}`,
+			wantCode: http.StatusOK, wantAllowed: true, wantVersion: "1.8.2", wantWarnings: 1},
+		{name: "violated", token: "valid", allowed: true, body: `{"namespace":"default","name":"wmc","resourceType":1,"containers":[{"name":"wmc","type":2,"image":"` + wmcNext + `"}]}`,
+			wantCode: http.StatusOK, wantVersion: "1.9.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := testutil.NewDeployment("wmc", wmc, "1.8.1", map[string]string{"ocm": "^2.0.0"})
+			ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "2.3.0", nil)
+			// 对wmc格式错误的约束不影响结果, 以警告返回
+			ccs := testutil.NewDeployment("ccs", "harbor:5000/wecloud/ccs:1.2.0", "1.2.0", map[string]string{"wmc": "1.x.y"})
+			c := reviewClient{Client: fake.NewClientBuilder().WithObjects(old.DeepCopy(), ocm, ccs).Build(), allowed: tt.allowed}
+			s := &Server{client: c, logger: logr.Discard()}
+
+			r := httptest.NewRequest(http.MethodPost, PathWhatIf, bytes.NewBufferString(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.authenticate(http.HandlerFunc(s.whatIf)).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("whatIf() code = %d, want %d, body %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp CheckResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("whatIf() allowed = %v, want %v, violations %v", resp.Allowed, tt.wantAllowed, resp.Violations)
+			}
+			if resp.Version != tt.wantVersion {
+				t.Errorf("whatIf() version = %q, want %q", resp.Version, tt.wantVersion)
+			}
+			if !tt.wantAllowed && len(resp.Violations) == 0 {
+				t.Error("whatIf() violations为空")
+			}
+			if len(resp.Warnings) != tt.wantWarnings {
+				t.Errorf("whatIf() warnings = %v, want %d", resp.Warnings, tt.wantWarnings)
+			}
+
+			// 集群中的对象不应被修改
+			var got appsv1.Deployment
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(old), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Spec.Template.Spec.Containers[0].Image != wmc || got.Labels[registry.K8sLabelVersion] != "1.8.1" {
+				t.Errorf("whatIf()修改了集群中的对象: %+v", got.ObjectMeta)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/server"
+	"gitlab.wellcloud.cc/cloud/dictator/webhook"
+	"os"
+	"time"
+)
+
+// Pod中挂载的ServiceAccount Token
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// 作为init容器运行, 等待服务依赖的服务就绪, 命名空间由注入的环境变量提供, CA由注入的卷挂载
+func waitForDependencies(ctx context.Context, logger logr.Logger, endpoint, name, caFile string, timeout, interval time.Duration) error {
+	namespace := os.Getenv(webhook.WaitNamespaceEnv)
+	if namespace == "" {
+		return errors.New("缺少环境变量" + webhook.WaitNamespaceEnv)
+	}
+	// 未挂载Token时匿名访问, 由dictator返回401
+	token, err := os.ReadFile(serviceAccountTokenPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// 未配置CA时使用系统CA
+	var ca []byte
+	if caFile != "" {
+		if ca, err = os.ReadFile(caFile); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return server.WaitForDependencies(ctx, logger, endpoint, namespace, name, string(token), ca, interval)
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"time"
+)
+
+// K8sAnnotationAppliedBy 通过dictator API提交变更的调用方, 值为JSON格式的AppliedBy
+// dictator鉴权通过后以自身身份提交变更, 只有请求用户为Options.ServiceAccount且本次请求修改了该annotation时,
+// webhook才以其中记录的调用方作为请求用户, 其他情况下忽略
+const K8sAnnotationAppliedBy = "dictator.wkm.welljoint.com/applied-by"
+
+// AppliedBy 通过dictator API提交变更的调用方
+type AppliedBy struct {
+	User authenticationv1.UserInfo `json:"user"`
+	// 提交时间, 使每次提交写入的值不同, 以区分本次请求写入的记录和对象上遗留的记录
+	Time v12.MicroTime `json:"time"`
+}
+
+// SetAppliedBy 在对象上记录通过dictator API提交变更的调用方
+func SetAppliedBy(obj v12.Object, user authenticationv1.UserInfo, now time.Time) error {
+	raw, err := json.Marshal(AppliedBy{User: user, Time: v12.NewMicroTime(now)})
+	if err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[K8sAnnotationAppliedBy] = string(raw)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// 返回发起准入请求的用户, 不在准入请求中时返回false
+// 请求由dictator代为提交时返回本次请求在K8sAnnotationAppliedBy中记录的调用方
+func requestUser(ctx context.Context, options Options, obj v12.Object) (authenticationv1.UserInfo, bool) {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return authenticationv1.UserInfo{}, false
+	}
+	if options.ServiceAccount == "" || req.UserInfo.Username != options.ServiceAccount {
+		return req.UserInfo, true
+	}
+	raw := obj.GetAnnotations()[K8sAnnotationAppliedBy]
+	if raw == "" {
+		return req.UserInfo, true
+	}
+	// 未被本次请求修改的记录来自之前的提交, 如dictator补充版本时对象上遗留的记录
+	if len(req.OldObject.Raw) > 0 {
+		var old v12.PartialObjectMetadata
+		if err = json.Unmarshal(req.OldObject.Raw, &old); err == nil && old.Annotations[K8sAnnotationAppliedBy] == raw {
+			return req.UserInfo, true
+		}
+	}
+	var appliedBy AppliedBy
+	if err = json.Unmarshal([]byte(raw), &appliedBy); err != nil || appliedBy.User.Username == "" {
+		return req.UserInfo, true
+	}
+	return appliedBy.User, true
+}
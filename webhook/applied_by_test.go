@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"testing"
+	"time"
+)
+
+func TestRequestUser(t *testing.T) {
+	const dictator = "system:serviceaccount:dictator-system:dictator"
+	caller := authenticationv1.UserInfo{Username: "deployer", Groups: []string{"release"}}
+	applied := &v12.ObjectMeta{Name: "wmc"}
+	if err := SetAppliedBy(applied, caller, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := json.Marshal(&v12.PartialObjectMetadata{ObjectMeta: *applied})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newContext := func(username string, oldObject []byte) context.Context {
+		return admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			UserInfo:  authenticationv1.UserInfo{Username: username},
+			OldObject: runtime.RawExtension{Raw: oldObject},
+		}})
+	}
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		serviceAccount string
+		obj            v12.Object
+		want           string
+		wantOK         bool
+	}{
+		{name: "not admission", ctx: context.Background(), serviceAccount: dictator, obj: applied},
+		{name: "user", ctx: newContext("kubectl", nil), serviceAccount: dictator, obj: &v12.ObjectMeta{Name: "wmc"}, want: "kubectl", wantOK: true},
+		{name: "applied by dictator", ctx: newContext(dictator, nil), serviceAccount: dictator, obj: applied, want: "deployer", wantOK: true},
+		// 其他用户写入的记录无效
+		{name: "forged", ctx: newContext("kubectl", nil), serviceAccount: dictator, obj: applied, want: "kubectl", wantOK: true},
+		// 对象上遗留的记录不代表本次请求的调用方
+		{name: "stale", ctx: newContext(dictator, stale), serviceAccount: dictator, obj: applied, want: dictator, wantOK: true},
+		{name: "unknown service account", ctx: newContext(dictator, nil), obj: applied, want: dictator, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := requestUser(tt.ctx, Options{ServiceAccount: tt.serviceAccount}, tt.obj)
+			if got.Username != tt.want || ok != tt.wantOK {
+				t.Errorf("requestUser() = %q, %v, want %q, %v", got.Username, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -11,21 +11,22 @@ import (
 )
 
 //+kubebuilder:webhook:path=/mutate-apps-v1-daemonset,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps,resources=daemonsets,verbs=create;update,versions=v1,name=mdaemonset.kb.io,admissionReviewVersions=v1
-//+kubebuilder:webhook:path=/validate-apps-v1-daemonset,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps,resources=daemonsets,verbs=create;update,versions=v1,name=vdaemonset.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-apps-v1-daemonset,mutating=false,failurePolicy=fail,sideEffects=NoneOnDryRun,groups=apps,resources=daemonsets,verbs=create;update,versions=v1,name=vdaemonset.kb.io,admissionReviewVersions=v1
 
 type DaemonSetWebhook struct {
-	client client.Client
-	logger logr.Logger
+	client  client.Client
+	logger  logr.Logger
+	options Options
 }
 
 func (d DaemonSetWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
 	d.logger.Info("收到validate webhook创建请求")
-	return UseValidate(d.logger, obj, d.client, ctx)
+	return UseValidate(d.logger, obj, d.client, d.options, ctx)
 }
 
 func (d DaemonSetWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
 	d.logger.Info("收到validate webhook更新请求")
-	return UseValidate(d.logger, newObj, d.client, ctx)
+	return UseValidateUpdate(d.logger, oldObj, newObj, d.client, d.options, ctx)
 }
 
 func (d DaemonSetWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) error {
@@ -34,17 +35,20 @@ func (d DaemonSetWebhook) ValidateDelete(ctx context.Context, obj runtime.Object
 }
 
 func (d DaemonSetWebhook) Default(ctx context.Context, obj runtime.Object) error {
-	return UseDefault(obj, d.logger)
+	return defaultWorkload(ctx, d.client, d.logger, d.options, obj)
 }
 
-func SetupDaemonSetWebhookWithManager(mgr ctrl.Manager) error {
+func SetupDaemonSetWebhookWithManager(mgr ctrl.Manager, options Options) error {
 	hook := &DaemonSetWebhook{
-		client: mgr.GetClient(),
-		logger: logf.Log.WithName("[webhook.deamonset]"),
+		client:  mgr.GetClient(),
+		logger:  logf.Log.WithName("[webhook.deamonset]"),
+		options: options,
 	}
-	return ctrl.NewWebhookManagedBy(mgr).
+	if err := ctrl.NewWebhookManagedBy(mgr).
 		For(&appsv1.DaemonSet{}).
 		WithDefaulter(hook).
-		WithValidator(hook).
-		Complete()
+		Complete(); err != nil {
+		return err
+	}
+	return registerValidator(mgr, &appsv1.DaemonSet{}, hook)
 }
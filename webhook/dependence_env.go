@@ -0,0 +1,40 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// K8sAnnotationInjectDependenceEnv 值为"true"时, 向工作负载的所有容器注入被依赖服务的版本, 如 WKM_DEP_OCM_VERSION=2.3.0
+	// 被依赖服务的版本变化时由合规检查控制器更新, 自定义工作负载只在自身更新时注入
+	K8sAnnotationInjectDependenceEnv = "dictator.wkm.welljoint.com/inject-dependence-env"
+
+	EventReasonDependenceEnv = "DependenceEnvUpdated" // 注入的被依赖服务版本已更新
+)
+
+// WantsDependenceEnv 工作负载是否要求注入被依赖服务的版本
+func WantsDependenceEnv(workload *registry.Workload) bool {
+	return workload.Meta.GetAnnotations()[K8sAnnotationInjectDependenceEnv] == "true"
+}
+
+// 要求注入时, 以命名空间中被依赖服务的当前版本设置环境变量
+func injectDependenceEnv(ctx context.Context, myClient client.Client, logger logr.Logger, workload *registry.Workload) error {
+	if !WantsDependenceEnv(workload) {
+		return nil
+	}
+	objsMap, err := ListWorkloads(ctx, myClient, logger, workload.Meta.GetNamespace())
+	if err != nil {
+		return err
+	}
+	changed, err := registry.InjectDependenceEnv(objsMap, workload)
+	if err != nil {
+		return err
+	}
+	if changed {
+		logger.Info("已注入被依赖服务的版本", "versions", registry.DependenceVersions(objsMap, workload))
+	}
+	return nil
+}
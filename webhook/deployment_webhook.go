@@ -18,15 +18,20 @@ package webhook
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/go-logr/logr"
 	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
-	corev1 "k8s.io/api/core/v1"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
@@ -34,23 +39,27 @@ import (
 //+kubebuilder:rbac:groups=apps,resources=deployments/finalizers,verbs=update
 
 //+kubebuilder:webhook:path=/mutate-apps-v1-deployment,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps,resources=deployments,verbs=create;update,versions=v1,name=mdeployment.kb.io,admissionReviewVersions=v1
-//+kubebuilder:webhook:path=/validate-apps-v1-deployment,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps,resources=deployments,verbs=create;update,versions=v1,name=vdeployment.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-apps-v1-deployment,mutating=false,failurePolicy=fail,sideEffects=NoneOnDryRun,groups=apps,resources=deployments,verbs=create;update,versions=v1,name=vdeployment.kb.io,admissionReviewVersions=v1
 
 type DeploymentWebhook struct {
-	client client.Client
-	logger logr.Logger
+	client  client.Client
+	logger  logr.Logger
+	options Options
 }
 
-func SetupDeploymentWebhookWithManager(mgr ctrl.Manager) error {
+func SetupDeploymentWebhookWithManager(mgr ctrl.Manager, options Options) error {
 	hook := &DeploymentWebhook{
-		client: mgr.GetClient(),
-		logger: logf.Log.WithName("[webhook.deployment]"),
+		client:  mgr.GetClient(),
+		logger:  logf.Log.WithName("[webhook.deployment]"),
+		options: options,
 	}
-	return ctrl.NewWebhookManagedBy(mgr).
+	if err := ctrl.NewWebhookManagedBy(mgr).
 		For(&appsv1.Deployment{}).
 		WithDefaulter(hook).
-		WithValidator(hook).
-		Complete()
+		Complete(); err != nil {
+		return err
+	}
+	return registerValidator(mgr, &appsv1.Deployment{}, hook)
 }
 
 const (
@@ -58,17 +67,17 @@ const (
 )
 
 func (w *DeploymentWebhook) Default(ctx context.Context, obj runtime.Object) error {
-	return UseDefault(obj, w.logger)
+	return defaultWorkload(ctx, w.client, w.logger, w.options, obj)
 }
 
 func (w *DeploymentWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
 	w.logger.Info("收到validate webhook创建请求")
-	return UseValidate(w.logger, obj, w.client, ctx)
+	return UseValidate(w.logger, obj, w.client, w.options, ctx)
 }
 
 func (w *DeploymentWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
 	w.logger.Info("收到validate webhook更新请求")
-	return UseValidate(w.logger, newObj, w.client, ctx)
+	return UseValidateUpdate(w.logger, oldObj, newObj, w.client, w.options, ctx)
 }
 
 func (w *DeploymentWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) error {
@@ -76,103 +85,256 @@ func (w *DeploymentWebhook) ValidateDelete(ctx context.Context, obj runtime.Obje
 	return nil
 }
 
-func UseValidate(logger logr.Logger, obj runtime.Object, myClient client.Client, ctx context.Context) error {
-	//获取所有的资源
-	var deploymetObjs appsv1.DeploymentList
-	var statefulsetObjs appsv1.StatefulSetList
-	var daemonsetObjs appsv1.DaemonSetList
-	var meta v12.ObjectMeta
-	var spec corev1.PodTemplateSpec
-	switch obj.(type) {
-	case *appsv1.Deployment:
-		meta = obj.(*appsv1.Deployment).ObjectMeta
-		spec = obj.(*appsv1.Deployment).Spec.Template
-	case *appsv1.StatefulSet:
-		meta = obj.(*appsv1.StatefulSet).ObjectMeta
-		spec = obj.(*appsv1.StatefulSet).Spec.Template
-	case *appsv1.DaemonSet:
-		meta = obj.(*appsv1.DaemonSet).ObjectMeta
-		spec = obj.(*appsv1.DaemonSet).Spec.Template
-	}
-
-	opts := client.ListOptions{
-		Namespace: meta.Namespace,
-	}
-	err := myClient.List(ctx, &deploymetObjs, &opts)
-	if err != nil {
-		logger.Info("获取所有Deployment资源失败", "err", err)
-		return err
+func UseValidate(logger logr.Logger, obj runtime.Object, myClient client.Client, options Options, ctx context.Context) error {
+	if IsExempt(ctx, myClient, logger, options, obj) {
+		return nil
 	}
-	err = myClient.List(ctx, &statefulsetObjs, &opts)
-	if err != nil {
-		logger.Info("获取所有StatefulSet资源失败", "err", err)
-		return err
+	workload, ok := registry.GetWorkload(obj)
+	if !ok {
+		logger.Info("不支持的资源类型", "type", fmt.Sprintf("%T", obj))
+		return nil
+	}
+
+	if err := validateWorkload(logger, workload, myClient, options, ctx); err != nil {
+		if err = applyOverride(ctx, myClient, logger, options, obj, workload.Meta, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseValidateUpdate 更新时先检查新旧版本是否符合升级策略
+// 容器镜像未变化(如副本数调整、annotation修改、rollout restart)时不访问镜像仓库,
+// 版本和依赖约束以mutate webhook沿用的label和annotation为准, 二者均未变化时跳过依赖检查, 只检查发布包
+func UseValidateUpdate(logger logr.Logger, oldObj, newObj runtime.Object, myClient client.Client, options Options, ctx context.Context) error {
+	oldWorkload, ok := registry.GetWorkload(oldObj)
+	if !ok {
+		return UseValidate(logger, newObj, myClient, options, ctx)
+	}
+	workload, ok := registry.GetWorkload(newObj)
+	if !ok {
+		return UseValidate(logger, newObj, myClient, options, ctx)
+	}
+	if IsExempt(ctx, myClient, logger, options, newObj) {
+		return nil
+	}
+	if err := validateUpdate(logger, oldWorkload, workload, myClient, options, ctx); err != nil {
+		if err = applyOverride(ctx, myClient, logger, options, newObj, workload.Meta, err); err != nil {
+			return err
+		}
 	}
-	err = myClient.List(ctx, &daemonsetObjs, &opts)
+	return nil
+}
+
+func validateUpdate(logger logr.Logger, oldWorkload, workload *registry.Workload, myClient client.Client, options Options, ctx context.Context) error {
+	gVersion := workload.Version()
+	err := options.UpgradePolicies.check(workload.Meta.GetNamespace(), workload.Meta.GetName(), oldWorkload.Version(), gVersion)
 	if err != nil {
-		logger.Info("获取所有DaemonSet资源失败", "err", err)
+		logger.Info("检测升级策略失败", "err", err)
 		return err
 	}
 
-	var objsMap = make(map[string]runtime.Object)
-	var objsReverseMap = make(map[string]*v12.ObjectMeta)
-	//拼接资源map
-	for _, v := range deploymetObjs.Items {
-		objsMap[v.Name] = &v
-		objsReverseMap[v.Name] = &v.ObjectMeta
+	if !registry.SameImages(oldWorkload.Template, workload.Template) || oldWorkload.Meta.GetLabels()[registry.K8sLabelVersion] == "" {
+		return validateWorkload(logger, workload, myClient, options, ctx)
+	}
+	deps := registry.GetObjDependence(workload.Meta)
+	if gVersion == oldWorkload.Version() && reflect.DeepEqual(deps, registry.GetObjDependence(oldWorkload.Meta)) &&
+		reflect.DeepEqual(registry.GetObjUserDependence(workload.Meta), registry.GetObjUserDependence(oldWorkload.Meta)) &&
+		reflect.DeepEqual(registry.GetObjCapability(workload.Meta), registry.GetObjCapability(oldWorkload.Meta)) {
+		logger.V(1).Info("镜像、版本和依赖约束均未变化, 跳过依赖检查")
+		// 命名空间可能在上次变更后才绑定发布包, 发布包检查只需查询命名空间和发布包, 仍然执行
+		if err = checkReleaseBundle(ctx, myClient, workload.Meta.GetNamespace(), workload.Meta.GetName(), gVersion); err != nil {
+			logger.Info("检测发布包失败", "err", err)
+			return err
+		}
+		return nil
 	}
-	for _, v := range statefulsetObjs.Items {
-		objsMap[v.Name] = &v
-		objsReverseMap[v.Name] = &v.ObjectMeta
+	return checkWorkload(logger, workload, gVersion, deps, myClient, options, ctx)
+}
 
+// 从镜像仓库获取版本和依赖约束并检查
+func validateWorkload(logger logr.Logger, workload *registry.Workload, myClient client.Client, options Options, ctx context.Context) error {
+	gVersion, deps, err := registry.GetVersionAndDependence(*workload.Template, options.DefaultPlatform)
+	if err != nil {
+		logger.Info("获取版本和依赖失败", "err", err)
+		return err
 	}
-	for _, v := range daemonsetObjs.Items {
-		objsMap[v.Name] = &v
-		objsReverseMap[v.Name] = &v.ObjectMeta
+	return checkWorkload(logger, workload, gVersion, deps.Constraints(), myClient, options, ctx)
+}
 
+// 对工作负载进行正向、反向依赖检查
+// deps为镜像声明的依赖约束, 用户在工作负载上声明的依赖约束单独检查, 以便在错误中区分来源
+func checkWorkload(logger logr.Logger, workload *registry.Workload, gVersion string, deps map[string]string, myClient client.Client, options Options, ctx context.Context) error {
+	if err := registry.ValidateObjDependence(workload.Meta); err != nil {
+		logger.Info("依赖约束格式错误", "err", err)
+		return err
+	}
+	userDeps := registry.GetObjUserDependence(workload.Meta)
+	if err := registry.ValidateUserDependence(userDeps, deps); err != nil {
+		logger.Info("检测用户声明的依赖约束失败", "err", err)
+		return err
 	}
 
-	//获取版本和依赖
-	gVersion, deps, err := registry.GetVersionAndDependence(spec)
+	//获取所有的资源
+	objsMap, err := ListWorkloads(ctx, myClient, logger, workload.Meta.GetNamespace())
 	if err != nil {
-		logger.Info("获取版本和依赖失败", "err", err)
 		return err
 	}
+	var objsReverseMap = make(map[string]v12.Object, len(objsMap))
+	var capabilityObjs = make(map[string]v12.Object, len(objsMap)+1)
+	for k, v := range objsMap {
+		if w, ok := registry.GetWorkload(v); ok {
+			objsReverseMap[k] = w.Meta
+			capabilityObjs[k] = w.Meta
+		}
+	}
+	// 能力以变更后的对象为准, 变更前提供的能力用于反向检查
+	var previousCapabilities map[string]string
+	if old := capabilityObjs[workload.Meta.GetName()]; old != nil {
+		previousCapabilities = registry.GetObjCapability(old)
+	}
+	capabilityObjs[workload.Meta.GetName()] = workload.Meta
 
 	//检测依赖
-	if err = registry.CheckForwardDependence(objsMap, deps); err != nil {
+	var live *liveObjects
+	if options.CheckLiveVersions || options.Readiness.Enabled() {
+		live, err = listLiveObjects(ctx, myClient, workload.Meta.GetNamespace())
+		if err != nil {
+			logger.Info("获取正在运行的ReplicaSet和Pod失败", "err", err)
+			return err
+		}
+	}
+	if options.CheckLiveVersions {
+		versions := live.serviceVersions(objsMap)
+		err = registry.CheckForwardDependenceWithVersions(objsMap, versions, deps)
+		if err == nil {
+			err = registry.CheckUserForwardDependenceWithVersions(objsMap, versions, userDeps)
+		}
+		for _, rs := range live.activeReplicaSets() {
+			objsReverseMap[string(rs.UID)] = rs
+		}
+	} else {
+		err = registry.CheckForwardDependence(objsMap, deps)
+		if err == nil {
+			err = registry.CheckUserForwardDependence(objsMap, userDeps)
+		}
+	}
+	if err != nil {
 		logger.Info("检测正向依赖失败", "err", err)
 		return err
 	}
-	if err = registry.CheckReverseDependence(objsReverseMap, meta.Name, gVersion); err != nil {
+	resolutions, err := registry.ResolveCapabilities(capabilityObjs, deps, userDeps)
+	if err != nil {
+		logger.Info("检测能力依赖失败", "err", err)
+		return err
+	}
+	for _, r := range resolutions {
+		logger.Info("能力依赖已满足", "capability", r.Capability, "constraint", r.Constraint, "provider", r.Provider, "version", r.Version)
+	}
+	if options.Readiness.Enabled() {
+		for _, d := range []map[string]string{deps, userDeps} {
+			if err = options.Readiness.check(live, objsMap, d); err != nil {
+				logger.Info("检测依赖就绪失败", "err", err)
+				return err
+			}
+		}
+	}
+	reportMalformed(ctx, logger, options.Recorder, registry.FindMalformedConstraints(objsReverseMap, workload.Meta.GetName()), objsMap)
+	if err = registry.CheckReverseDependence(objsReverseMap, workload.Meta.GetName(), gVersion); err != nil {
 		logger.Info("检测反向依赖失败", "err", err)
 		return err
 	}
+	if err = registry.CheckReverseCapability(capabilityObjs, workload.Meta.GetName(), previousCapabilities); err != nil {
+		logger.Info("检测反向能力依赖失败", "err", err)
+		return err
+	}
+	if err = checkReleaseBundle(ctx, myClient, workload.Meta.GetNamespace(), workload.Meta.GetName(), gVersion); err != nil {
+		logger.Info("检测发布包失败", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 设置版本和依赖约束, 更新时容器镜像未变化则沿用原对象的版本和依赖约束, 不访问镜像仓库
+// 工作负载要求注入时, 设置被依赖服务版本的环境变量和等待依赖就绪的init容器
+func defaultWorkload(ctx context.Context, myClient client.Client, logger logr.Logger, options Options, obj runtime.Object) error {
+	if IsExempt(ctx, myClient, logger, options, obj) {
+		return nil
+	}
+	oldObj := getOldObject(ctx, obj)
+	if oldObj != nil && reuseVersion(oldObj, obj) {
+		logger.Info("镜像未变化, 沿用版本和依赖约束")
+	} else if err := UseDefault(obj, logger, options); err != nil {
+		return err
+	}
+	if workload, ok := registry.GetWorkload(obj); ok {
+		stampChangedBy(ctx, options, oldObj, workload)
+		if err := injectDependenceEnv(ctx, myClient, logger, workload); err != nil {
+			logger.Info("注入被依赖服务的版本失败", "err", err)
+			return err
+		}
+		if err := injectWaitContainer(logger, options.Wait, workload); err != nil {
+			logger.Info("注入等待依赖就绪的容器失败", "err", err)
+			return err
+		}
+		// 拒绝用户写入的格式错误的依赖约束, 避免影响其他服务的检查
+		return registry.ValidateObjDependence(workload.Meta)
+	}
 	return nil
 }
-func UseDefault(obj runtime.Object, logger logr.Logger) error {
+
+// 从更新请求中解析原对象, 不是更新请求或解析失败时返回nil
+func getOldObject(ctx context.Context, obj runtime.Object) runtime.Object {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil || req.Operation != admissionv1.Update || len(req.OldObject.Raw) == 0 {
+		return nil
+	}
+	var oldObj runtime.Object
+	if _, ok := obj.(*unstructured.Unstructured); ok {
+		oldObj = &unstructured.Unstructured{}
+	} else {
+		oldObj = reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	}
+	if err = json.Unmarshal(req.OldObject.Raw, oldObj); err != nil {
+		return nil
+	}
+	return oldObj
+}
+
+// 容器镜像未变化时将原对象的版本和依赖约束写入新对象
+func reuseVersion(oldObj, obj runtime.Object) bool {
+	oldWorkload, ok := registry.GetWorkload(oldObj)
+	if !ok {
+		return false
+	}
+	workload, ok := registry.GetWorkload(obj)
+	if !ok {
+		return false
+	}
+	version := oldWorkload.Meta.GetLabels()[registry.K8sLabelVersion]
+	if version == "" || !registry.SameImages(oldWorkload.Template, workload.Template) {
+		return false
+	}
+	registry.SetObjVersion(workload.Meta, version, registry.GetObjDependence(oldWorkload.Meta))
+	registry.SetObjDependenceSources(workload.Meta, registry.GetObjDependenceSources(oldWorkload.Meta))
+	registry.SetObjCapability(workload.Meta, registry.GetObjCapability(oldWorkload.Meta))
+	return true
+}
+
+func UseDefault(obj runtime.Object, logger logr.Logger, options Options) error {
 	logger.Info("收到mutate webhook请求")
-	var spec corev1.PodTemplateSpec
-	var objN v12.ObjectMeta
-	switch obj.(type) {
-	case *appsv1.Deployment:
-		spec = obj.(*appsv1.Deployment).Spec.Template
-		objN = obj.(*appsv1.Deployment).ObjectMeta
-	case *appsv1.StatefulSet:
-		spec = obj.(*appsv1.StatefulSet).Spec.Template
-		objN = obj.(*appsv1.StatefulSet).ObjectMeta
-	case *appsv1.DaemonSet:
-		spec = obj.(*appsv1.DaemonSet).Spec.Template
-		objN = obj.(*appsv1.DaemonSet).ObjectMeta
-	}
-	gVersion, deps, err := registry.GetVersionAndDependence(spec)
+	workload, ok := registry.GetWorkload(obj)
+	if !ok {
+		logger.Info("不支持的资源类型", "type", fmt.Sprintf("%T", obj))
+		return nil
+	}
+	gVersion, deps, capabilities, err := registry.GetVersionDependenceAndCapability(*workload.Template, options.DefaultPlatform)
 	if err != nil {
 		return err
 	}
-	//设置Annotation
-	registry.SetObjVersion(&objN, gVersion, deps)
-	for k, v := range deps {
-		objN.Annotations[k+K8sAnnotationDependence] = v
-	}
+	//设置Label和Annotation
+	registry.SetObjVersion(workload.Meta, gVersion, deps.Constraints())
+	registry.SetObjDependenceSources(workload.Meta, deps.Sources())
+	registry.SetObjCapability(workload.Meta, capabilities)
 	return nil
 }
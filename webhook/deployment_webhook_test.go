@@ -7,6 +7,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"testing"
 )
 
@@ -25,7 +26,7 @@ func TestDeploymentWebhook_Default(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		{name: "test", fields: fields{}, args: args{
+		{name: "test", fields: fields{client: fake.NewClientBuilder().Build(), logger: logr.Discard()}, args: args{
 			ctx: context.Background(),
 			obj: &v1.Deployment{
 				TypeMeta:   metav1.TypeMeta{},
@@ -64,7 +65,7 @@ func TestDeploymentWebhook_ValidateCreate(t *testing.T) {
 		wantErr bool
 	}{
 		{
-			name: "test", fields: fields{}, args: args{
+			name: "test", fields: fields{client: fake.NewClientBuilder().Build(), logger: logr.Discard()}, args: args{
 				ctx: context.Background(),
 				obj: &v1.Deployment{
 					TypeMeta:   metav1.TypeMeta{},
@@ -104,7 +105,7 @@ func TestDeploymentWebhook_ValidateUpdate(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		{name: "test", fields: fields{}, args: args{
+		{name: "test", fields: fields{client: fake.NewClientBuilder().Build(), logger: logr.Discard()}, args: args{
 			ctx: context.Background(),
 			oldObj: &v1.Deployment{
 				TypeMeta:   metav1.TypeMeta{},
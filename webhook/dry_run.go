@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// DryRun 以userInfo发起更新请求的方式对newObj依次执行mutate和validate, 与webhook的处理一致, 不修改集群中的对象
+// newObj中的版本label和依赖约束annotation会被更新, 返回检查中产生的警告和validate的结果
+func DryRun(ctx context.Context, myClient client.Client, logger logr.Logger, options Options, userInfo authenticationv1.UserInfo, oldObj, newObj runtime.Object) ([]string, error) {
+	accessor, err := meta.Accessor(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	dryRun := true
+	ctx = admission.NewContextWithRequest(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		Namespace: accessor.GetNamespace(),
+		Name:      accessor.GetName(),
+		UserInfo:  userInfo,
+		OldObject: runtime.RawExtension{Raw: raw},
+		DryRun:    &dryRun,
+	}})
+	ctx, warnings := withWarnings(ctx)
+
+	if err = defaultWorkload(ctx, myClient, logger, options, newObj); err != nil {
+		return warnings.messages, err
+	}
+	err = UseValidateUpdate(logger, oldObj, newObj, myClient, options, ctx)
+	return warnings.messages, err
+}
@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// 始终豁免的系统命名空间, 避免镜像仓库不可用时无法恢复系统组件, 其他命名空间通过Options.SystemNamespaces追加
+var systemNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// ExemptionConfig 豁免配置, 被豁免的对象跳过mutate和validate, 格式如:
+//
+//	namespaces:
+//	- monitoring
+//	namespaceSelector:
+//	  matchLabels:
+//	    wkm.welljoint.com/exempt: "true"
+//	objectSelector:
+//	  matchExpressions:
+//	  - key: wkm.welljoint.com/exempt
+//	    operator: Exists
+//	users:
+//	- system:serviceaccount:argocd:argocd-application-controller
+//	groups:
+//	- system:serviceaccounts:flux-system
+type ExemptionConfig struct {
+	Namespaces        []string           `json:"namespaces,omitempty"`
+	NamespaceSelector *v12.LabelSelector `json:"namespaceSelector,omitempty"`
+	ObjectSelector    *v12.LabelSelector `json:"objectSelector,omitempty"`
+	// 发起请求的用户或用户组
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// LoadExemptionConfig 从配置文件中读取豁免配置
+func LoadExemptionConfig(path string) (ExemptionConfig, error) {
+	var cfg ExemptionConfig
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err = yaml.UnmarshalStrict(raw, &cfg); err != nil {
+		return cfg, err
+	}
+	for _, selector := range []*v12.LabelSelector{cfg.NamespaceSelector, cfg.ObjectSelector} {
+		if _, err = v12.LabelSelectorAsSelector(selector); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// 判断对象是否被豁免, 返回豁免原因, extraSystemNamespaces为追加的系统命名空间, user为发起请求的用户, 不在准入请求中时为nil
+func (e ExemptionConfig) reason(ctx context.Context, myClient client.Client, extraSystemNamespaces []string, user *authenticationv1.UserInfo, obj v12.Object) (string, error) {
+	req, reqErr := admission.RequestFromContext(ctx)
+	namespace := obj.GetNamespace()
+	if namespace == "" && reqErr == nil {
+		// 创建请求中的对象可能未设置命名空间
+		namespace = req.Namespace
+	}
+	for _, namespaces := range [][]string{systemNamespaces, extraSystemNamespaces} {
+		for _, ns := range namespaces {
+			if ns == namespace {
+				return "系统命名空间", nil
+			}
+		}
+	}
+	for _, ns := range e.Namespaces {
+		if ns == namespace {
+			return "命名空间", nil
+		}
+	}
+
+	if user != nil {
+		for _, username := range e.Users {
+			if username == user.Username {
+				return "用户" + username, nil
+			}
+		}
+		for _, group := range e.Groups {
+			for _, g := range user.Groups {
+				if group == g {
+					return "用户组" + group, nil
+				}
+			}
+		}
+	}
+
+	if e.ObjectSelector != nil {
+		selector, err := v12.LabelSelectorAsSelector(e.ObjectSelector)
+		if err != nil {
+			return "", err
+		}
+		if selector.Matches(labels.Set(obj.GetLabels())) {
+			return "对象标签", nil
+		}
+	}
+	if e.NamespaceSelector != nil && namespace != "" {
+		selector, err := v12.LabelSelectorAsSelector(e.NamespaceSelector)
+		if err != nil {
+			return "", err
+		}
+		var ns corev1.Namespace
+		if err = myClient.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+			return "", fmt.Errorf("获取命名空间%s失败: %w", namespace, err)
+		}
+		if selector.Matches(labels.Set(ns.Labels)) {
+			return "命名空间标签", nil
+		}
+	}
+	return "", nil
+}
+
+// IsExempt 判断对象是否被豁免并记录日志, 无法判断时不豁免
+// webhook、合规检查和补充任务使用同一判断, 保证豁免的对象在各处都被跳过
+func IsExempt(ctx context.Context, myClient client.Client, logger logr.Logger, options Options, obj runtime.Object) bool {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	var user *authenticationv1.UserInfo
+	if u, ok := requestUser(ctx, options, accessor); ok {
+		user = &u
+	}
+	reason, err := options.Exemptions.reason(ctx, myClient, options.SystemNamespaces, user, accessor)
+	if err != nil {
+		logger.Info("判断豁免失败", "namespace", accessor.GetNamespace(), "name", accessor.GetName(), "err", err)
+		return false
+	}
+	if reason == "" {
+		return false
+	}
+	logger.Info("对象已豁免, 跳过依赖检查", "namespace", accessor.GetNamespace(), "name", accessor.GetName(), "reason", reason)
+	return true
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"testing"
+)
+
+func TestIsExempt(t *testing.T) {
+	exempt := map[string]string{"wkm.welljoint.com/exempt": "true"}
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Labels: exempt}},
+	).Build()
+	options := Options{SystemNamespaces: []string{"dictator-system"}, Exemptions: ExemptionConfig{
+		Namespaces:        []string{"monitoring"},
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: exempt},
+		ObjectSelector:    &metav1.LabelSelector{MatchLabels: exempt},
+		Users:             []string{"system:serviceaccount:argocd:argocd-application-controller"},
+		Groups:            []string{"system:serviceaccounts:flux-system"},
+	}}
+
+	tests := []struct {
+		name     string
+		obj      *corev1.Pod
+		userInfo authenticationv1.UserInfo
+		want     bool
+	}{
+		{name: "not exempt", obj: newTestPod("default", nil), userInfo: authenticationv1.UserInfo{Username: "admin"}},
+		{name: "system namespace", obj: newTestPod("kube-system", nil), want: true},
+		{name: "extra system namespace", obj: newTestPod("dictator-system", nil), want: true},
+		{name: "namespace", obj: newTestPod("monitoring", nil), want: true},
+		{name: "namespace selector", obj: newTestPod("legacy", nil), want: true},
+		{name: "object selector", obj: newTestPod("default", exempt), want: true},
+		{name: "user", obj: newTestPod("default", nil), userInfo: authenticationv1.UserInfo{Username: "system:serviceaccount:argocd:argocd-application-controller"}, want: true},
+		{name: "group", obj: newTestPod("default", nil), userInfo: authenticationv1.UserInfo{Groups: []string{"system:serviceaccounts", "system:serviceaccounts:flux-system"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{Namespace: tt.obj.Namespace, UserInfo: tt.userInfo},
+			})
+			if got := IsExempt(ctx, c, logr.Discard(), options, tt.obj); got != tt.want {
+				t.Errorf("IsExempt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestPod(namespace string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "wmc", Labels: labels}}
+}
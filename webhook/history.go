@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"context"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+//+kubebuilder:rbac:groups=wkm.welljoint.com,resources=versionhistories,verbs=get;list;watch
+
+// HistoryRecord 带服务名的版本变更记录
+type HistoryRecord struct {
+	Service string `json:"service"`
+	wkmv1alpha1.VersionHistoryEntry
+}
+
+// 版本变更时在工作负载上记录发起变更的用户, 对象写入后由版本历史控制器读取
+// 版本未变化时沿用原对象的记录, 避免被请求中的annotation覆盖; oldObj为nil表示创建
+func stampChangedBy(ctx context.Context, options Options, oldObj runtime.Object, workload *registry.Workload) {
+	user, ok := requestUser(ctx, options, workload.Meta)
+	if !ok {
+		return
+	}
+	var oldVersion, oldUser string
+	if oldObj != nil {
+		if oldWorkload, ok := registry.GetWorkload(oldObj); ok {
+			oldVersion = oldWorkload.Meta.GetLabels()[registry.K8sLabelVersion]
+			oldUser = oldWorkload.Meta.GetAnnotations()[registry.K8sAnnotationChangedBy]
+		}
+	}
+	annotations := workload.Meta.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if version := workload.Meta.GetLabels()[registry.K8sLabelVersion]; version != "" && version != oldVersion {
+		annotations[registry.K8sAnnotationChangedBy] = user.Username
+	} else if oldUser != "" {
+		annotations[registry.K8sAnnotationChangedBy] = oldUser
+	} else {
+		delete(annotations, registry.K8sAnnotationChangedBy)
+	}
+	workload.Meta.SetAnnotations(annotations)
+}
+
+// ListHistory 查询命名空间下服务的版本历史, svc为空时返回所有服务, 按时间倒序排列
+func ListHistory(ctx context.Context, myClient client.Client, namespace, svc string) ([]HistoryRecord, error) {
+	var histories []wkmv1alpha1.VersionHistory
+	if svc != "" {
+		var history wkmv1alpha1.VersionHistory
+		if err := myClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: svc}, &history); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		histories = append(histories, history)
+	} else {
+		var list wkmv1alpha1.VersionHistoryList
+		if err := myClient.List(ctx, &list, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		histories = list.Items
+	}
+
+	var records []HistoryRecord
+	for _, h := range histories {
+		// 时间精确到秒, 同一服务按记录顺序倒序排列
+		for i := len(h.Spec.Entries) - 1; i >= 0; i-- {
+			records = append(records, HistoryRecord{Service: h.Name, VersionHistoryEntry: h.Spec.Entries[i]})
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[j].Time.Before(&records[i].Time)
+	})
+	return records, nil
+}
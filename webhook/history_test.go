@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"testing"
+	"time"
+)
+
+func TestStampChangedBy(t *testing.T) {
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UserInfo: authenticationv1.UserInfo{Username: "deployer"},
+	}})
+	newObj := func(version, changedBy string) runtime.Object {
+		d := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:"+version, "", nil)
+		registry.SetObjVersion(&d.ObjectMeta, version, nil)
+		if changedBy != "" {
+			d.Annotations[registry.K8sAnnotationChangedBy] = changedBy
+		}
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		oldObj runtime.Object
+		obj    runtime.Object
+		want   string
+	}{
+		{name: "create", obj: newObj("1.8.0", ""), want: "deployer"},
+		{name: "version changed", oldObj: newObj("1.8.0", "admin"), obj: newObj("1.8.1", "admin"), want: "deployer"},
+		{name: "version unchanged", oldObj: newObj("1.8.0", "admin"), obj: newObj("1.8.0", ""), want: "admin"},
+		{name: "tampered", oldObj: newObj("1.8.0", "admin"), obj: newObj("1.8.0", "someone"), want: "admin"},
+		{name: "unknown user", oldObj: newObj("1.8.0", ""), obj: newObj("1.8.0", "someone"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload, _ := registry.GetWorkload(tt.obj)
+			stampChangedBy(ctx, Options{}, tt.oldObj, workload)
+			if got := workload.Meta.GetAnnotations()[registry.K8sAnnotationChangedBy]; got != tt.want {
+				t.Errorf("stampChangedBy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListHistory(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := wkmv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	newEntry := func(version string, ago time.Duration) wkmv1alpha1.VersionHistoryEntry {
+		return wkmv1alpha1.VersionHistoryEntry{Time: v12.NewTime(now.Add(-ago)), Kind: "Deployment", NewVersion: version}
+	}
+	wmc := &wkmv1alpha1.VersionHistory{
+		ObjectMeta: v12.ObjectMeta{Namespace: "default", Name: "wmc"},
+		Spec:       wkmv1alpha1.VersionHistorySpec{Entries: []wkmv1alpha1.VersionHistoryEntry{newEntry("1.8.1", 2*time.Hour), newEntry("1.8.2", time.Hour)}},
+	}
+	ocm := &wkmv1alpha1.VersionHistory{
+		ObjectMeta: v12.ObjectMeta{Namespace: "default", Name: "ocm"},
+		Spec:       wkmv1alpha1.VersionHistorySpec{Entries: []wkmv1alpha1.VersionHistoryEntry{newEntry("2.3.0", 0)}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(wmc, ocm).Build()
+
+	records, err := ListHistory(context.Background(), c, "default", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Service != "ocm" || records[1].NewVersion != "1.8.2" || records[2].NewVersion != "1.8.1" {
+		t.Errorf("ListHistory() = %+v", records)
+	}
+	if records, err = ListHistory(context.Background(), c, "default", "wmc"); err != nil || len(records) != 2 {
+		t.Errorf("ListHistory(wmc) = %+v, %v", records, err)
+	}
+	if records, err = ListHistory(context.Background(), c, "default", "cms"); err != nil || len(records) != 0 {
+		t.Errorf("ListHistory(cms) = %+v, %v", records, err)
+	}
+}
@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"context"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+// 命名空间下正在运行的ReplicaSet和Pod, 按控制器UID分组
+type liveObjects struct {
+	replicaSets map[types.UID][]*appsv1.ReplicaSet
+	pods        map[types.UID][]*corev1.Pod
+}
+
+func listLiveObjects(ctx context.Context, myClient client.Client, namespace string) (*liveObjects, error) {
+	var rsList appsv1.ReplicaSetList
+	if err := myClient.List(ctx, &rsList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var podList corev1.PodList
+	if err := myClient.List(ctx, &podList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	live := &liveObjects{
+		replicaSets: make(map[types.UID][]*appsv1.ReplicaSet),
+		pods:        make(map[types.UID][]*corev1.Pod),
+	}
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		owner := v12.GetControllerOf(rs)
+		if owner == nil || rs.Status.Replicas == 0 {
+			continue
+		}
+		live.replicaSets[owner.UID] = append(live.replicaSets[owner.UID], rs)
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		owner := v12.GetControllerOf(pod)
+		if owner == nil || pod.DeletionTimestamp != nil ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		live.pods[owner.UID] = append(live.pods[owner.UID], pod)
+	}
+	return live, nil
+}
+
+// 获取工作负载的所有版本: 声明的版本, 仍有副本的ReplicaSet的版本(Deployment、Rollout),
+// 以及直接管理的Pod的版本(StatefulSet分区更新、DaemonSet、CloneSet)
+func (l *liveObjects) versions(obj runtime.Object) []string {
+	workload, ok := registry.GetWorkload(obj)
+	if !ok {
+		return nil
+	}
+	var versions []string
+	seen := make(map[string]bool)
+	add := func(version string) {
+		if version != "" && !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+
+	add(workload.Version())
+	uid := workload.Meta.GetUID()
+	for _, rs := range l.replicaSets[uid] {
+		add(registry.GetPodVersion(&corev1.Pod{Spec: rs.Spec.Template.Spec}))
+	}
+	for _, pod := range l.pods[uid] {
+		add(registry.GetPodVersion(pod))
+	}
+	return versions
+}
+
+// 获取工作负载管理的Pod, 包括通过ReplicaSet管理的Pod
+func (l *liveObjects) workloadPods(obj runtime.Object) []*corev1.Pod {
+	workload, ok := registry.GetWorkload(obj)
+	if !ok {
+		return nil
+	}
+	uid := workload.Meta.GetUID()
+	pods := append([]*corev1.Pod{}, l.pods[uid]...)
+	for _, rs := range l.replicaSets[uid] {
+		pods = append(pods, l.pods[rs.UID]...)
+	}
+	return pods
+}
+
+// 获取所有服务的版本
+func (l *liveObjects) serviceVersions(objs map[string]runtime.Object) map[string][]string {
+	results := make(map[string][]string, len(objs))
+	for svc, obj := range objs {
+		results[svc] = l.versions(obj)
+	}
+	return results
+}
+
+// 仍有副本的ReplicaSet, 其上记录了创建时所属工作负载的依赖约束
+func (l *liveObjects) activeReplicaSets() []*appsv1.ReplicaSet {
+	var results []*appsv1.ReplicaSet
+	for _, list := range l.replicaSets {
+		results = append(results, list...)
+	}
+	return results
+}
@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func TestUseValidate_LiveVersions(t *testing.T) {
+	host := testutil.NewRegistry(t)
+	wmc := testutil.NewDeployment("wmc", testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^2.0.0"}), "", nil)
+
+	// ocm正在从1.9.0滚动更新到2.3.0, 旧的ReplicaSet仍有副本
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "", nil)
+	isController := true
+	newRS := func(name, image string, replicas int32) *appsv1.ReplicaSet {
+		return &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "default",
+				Name:            name,
+				UID:             types.UID("uid-" + name),
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "ocm", UID: ocm.UID, Controller: &isController}},
+			},
+			Spec:   appsv1.ReplicaSetSpec{Template: testutil.NewDeployment("ocm", image, "", nil).Spec.Template},
+			Status: appsv1.ReplicaSetStatus{Replicas: replicas},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		oldRS   *appsv1.ReplicaSet
+		live    bool
+		wantErr bool
+	}{
+		{name: "spec only", oldRS: newRS("ocm-old", "harbor:5000/wecloud/ocm:1.9.0", 2), live: false},
+		{name: "old version serving", oldRS: newRS("ocm-old", "harbor:5000/wecloud/ocm:1.9.0", 2), live: true, wantErr: true},
+		{name: "old version scaled down", oldRS: newRS("ocm-old", "harbor:5000/wecloud/ocm:1.9.0", 0), live: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(ocm.DeepCopy(), tt.oldRS, newRS("ocm-new", "harbor:5000/wecloud/ocm:2.3.0", 1)).Build()
+			if err := UseValidate(logr.Discard(), wmc.DeepCopy(), c, Options{CheckLiveVersions: tt.live}, context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("UseValidate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUseValidate_LiveReverse(t *testing.T) {
+	host := testutil.NewRegistry(t)
+	ocm := testutil.NewDeployment("ocm", testutil.PushImage(t, host, "wecloud/ocm:2.3.0", nil), "", nil)
+
+	// wmc已更新为依赖^2.0.0, 但依赖^1.0.0的旧ReplicaSet仍有副本
+	isController := true
+	oldRS := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "wmc-old",
+			UID:             "wmc-old-uid",
+			Annotations:     map[string]string{"ocm" + K8sAnnotationDependence: "^1.0.0"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "wmc", UID: "wmc-uid", Controller: &isController}},
+		},
+		Status: appsv1.ReplicaSetStatus{Replicas: 1},
+	}
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "", nil)
+	wmc.Annotations = map[string]string{"ocm" + K8sAnnotationDependence: "^2.0.0"}
+	c := fake.NewClientBuilder().WithObjects(wmc, oldRS).Build()
+
+	for _, live := range []bool{false, true} {
+		err := UseValidate(logr.Discard(), ocm.DeepCopy(), c, Options{CheckLiveVersions: live}, context.Background())
+		if (err != nil) != live {
+			t.Errorf("UseValidate() live = %v, error = %v", live, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	corev1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	EventReasonMalformed = "MalformedDependence"
+)
+
+// MalformedConstraints 检查其他服务时发现的格式错误的依赖约束次数
+var MalformedConstraints = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "dictator_malformed_constraints_total",
+	Help: "Number of times a malformed dependence constraint was skipped while checking another workload.",
+}, []string{"namespace", "name", "annotation"})
+
+func init() {
+	metrics.Registry.MustRegister(MalformedConstraints)
+}
+
+// 报告其他对象上格式错误的依赖约束, 不影响当前对象的准入, 以警告返回给请求方
+// 正在运行的ReplicaSet复制了Deployment的annotation, 同一约束按控制器只报告一次, 指标和事件记录在控制器上
+// objs用于查找事件关联的对象, 找不到时只记录日志和指标
+func reportMalformed(ctx context.Context, logger logr.Logger, recorder record.EventRecorder, malformed []registry.MalformedConstraint, objs map[string]runtime.Object) {
+	reported := make(map[string]bool, len(malformed))
+	for _, m := range malformed {
+		name := m.Object.GetName()
+		if owner := v12.GetControllerOf(m.Object); owner != nil && objs[owner.Name] != nil {
+			name = owner.Name
+		}
+		key := name + "/" + m.Annotation + "=" + m.Constraint
+		if reported[key] {
+			continue
+		}
+		reported[key] = true
+
+		logger.Info("警告: 忽略格式错误的依赖约束", "object", m.Object.GetName(), "annotation", m.Annotation,
+			"constraint", m.Constraint, "err", m.Err.Error())
+		addWarning(ctx, "已忽略格式错误的依赖约束, "+m.Error())
+		MalformedConstraints.WithLabelValues(m.Object.GetNamespace(), name, m.Annotation).Inc()
+		if recorder == nil {
+			continue
+		}
+		obj, ok := objs[name]
+		if !ok {
+			obj, _ = m.Object.(runtime.Object)
+		}
+		if obj != nil {
+			recorder.Event(obj, corev1.EventTypeWarning, EventReasonMalformed, m.Error())
+		}
+	}
+}
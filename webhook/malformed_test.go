@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+	"testing"
+)
+
+func TestUseValidate_MalformedConstraint(t *testing.T) {
+	host := testutil.NewRegistry(t)
+	ocmImage := testutil.PushImage(t, host, "wecloud/ocm:2.3.0", nil)
+	key := "ocm" + K8sAnnotationDependence
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "", nil)
+	registry.SetObjVersion(wmc, "1.8.1", map[string]string{"ocm": "2.x.y"})
+	c := fake.NewClientBuilder().WithObjects(wmc).Build()
+
+	recorder := record.NewFakeRecorder(1)
+	before := promtestutil.ToFloat64(MalformedConstraints.WithLabelValues("default", "wmc", key))
+
+	// 其他对象上格式错误的约束不阻塞准入
+	ocm := testutil.NewDeployment("ocm", ocmImage, "", nil)
+	if err := UseDefault(ocm, logr.Discard(), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UseValidate(logr.Discard(), ocm, c, Options{Recorder: recorder}, context.Background()); err != nil {
+		t.Fatalf("UseValidate() error = %v", err)
+	}
+	if got := promtestutil.ToFloat64(MalformedConstraints.WithLabelValues("default", "wmc", key)) - before; got != 1 {
+		t.Errorf("MalformedConstraints = %v, want 1", got)
+	}
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, EventReasonMalformed) || !strings.Contains(e, key) {
+			t.Errorf("event = %q", e)
+		}
+	default:
+		t.Error("未记录事件")
+	}
+
+	// 写入格式错误的约束的对象被拒绝
+	ocm.Annotations["cms"+registry.K8sAnnotationUserDependence] = "latest"
+	if err := defaultWorkload(context.Background(), c, logr.Discard(), Options{Recorder: recorder}, ocm); err == nil {
+		t.Error("defaultWorkload() error = nil, want malformed")
+	}
+	if err := UseValidate(logr.Discard(), ocm, c, Options{Recorder: recorder}, context.Background()); err == nil {
+		t.Error("UseValidate() error = nil, want malformed")
+	}
+}
+
+func TestReportMalformed(t *testing.T) {
+	key := "ocm" + K8sAnnotationDependence
+	wmc := testutil.NewDeployment("wmc", "harbor:5000/wecloud/wmc:1.8.1", "", nil)
+	registry.SetObjVersion(wmc, "1.8.1", map[string]string{"ocm": "2.x.y"})
+	// 正在运行的ReplicaSet复制了Deployment的annotation
+	rs := &appsv1.ReplicaSet{ObjectMeta: v12.ObjectMeta{
+		Namespace:       "default",
+		Name:            "wmc-5d8f7c",
+		Annotations:     wmc.Annotations,
+		OwnerReferences: []v12.OwnerReference{*v12.NewControllerRef(wmc, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+	}}
+	objs := map[string]runtime.Object{"wmc": wmc}
+	malformed := registry.FindMalformedConstraints(map[string]v12.Object{"wmc": wmc, "wmc-5d8f7c": rs}, "ocm")
+	if len(malformed) != 2 {
+		t.Fatalf("FindMalformedConstraints() = %v, want 2", malformed)
+	}
+
+	recorder := record.NewFakeRecorder(2)
+	before := promtestutil.ToFloat64(MalformedConstraints.WithLabelValues("default", "wmc", key))
+	ctx, warnings := withWarnings(context.Background())
+	reportMalformed(ctx, logr.Discard(), recorder, malformed, objs)
+
+	if got := promtestutil.ToFloat64(MalformedConstraints.WithLabelValues("default", "wmc", key)) - before; got != 1 {
+		t.Errorf("MalformedConstraints = %v, want 1", got)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("events = %d, want 1", len(recorder.Events))
+	}
+	if len(warnings.messages) != 1 || !strings.Contains(warnings.messages[0], key) {
+		t.Errorf("warnings = %v", warnings.messages)
+	}
+}
+
+func TestWarningHandler(t *testing.T) {
+	h := warningHandler{Handler: admission.HandlerFunc(func(ctx context.Context, req admission.Request) admission.Response {
+		addWarning(ctx, "已忽略格式错误的依赖约束")
+		addWarning(ctx, "已忽略格式错误的依赖约束")
+		return admission.Allowed("")
+	})}
+	resp := h.Handle(context.Background(), admission.Request{})
+	if !resp.Allowed || !reflect.DeepEqual(resp.Warnings, []string{"已忽略格式错误的依赖约束"}) {
+		t.Errorf("Handle() = %+v", resp.AdmissionResponse)
+	}
+}
@@ -0,0 +1,35 @@
+package webhook
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"k8s.io/client-go/tools/record"
+	"time"
+)
+
+// Options 工作负载webhook的依赖检查和注入配置, 由main根据启动参数设置
+type Options struct {
+	// 为true时, 依赖检查同时考虑正在运行的版本:
+	// 正向检查要求被依赖服务的声明版本和仍有副本的ReplicaSet、Pod的版本都符合约束;
+	// 反向检查同时检查仍有副本的旧ReplicaSet上记录的依赖约束
+	CheckLiveVersions bool
+	// 正向依赖检查时对被依赖服务就绪副本的要求
+	Readiness ReadinessRequirement
+	// 等待依赖就绪的init容器配置
+	Wait WaitConfig
+	// 追加的始终豁免的命名空间, 如dictator所在的命名空间
+	SystemNamespaces []string
+	// 豁免配置
+	Exemptions ExemptionConfig
+	// 覆盖的最长有效期, 过期时间超出时覆盖无效, 为0时使用DefaultOverrideMaxDuration
+	OverrideMaxDuration time.Duration
+	// 版本升级策略
+	UpgradePolicies UpgradePolicyConfig
+	// 多架构镜像未通过nodeSelector指定平台时读取的平台
+	// 为空时读取所有平台, 并要求各平台声明的依赖约束一致
+	DefaultPlatform *v1.Platform
+	// dictator自身的用户名, 如system:serviceaccount:dictator-system:dictator
+	// 由其代为提交的请求以K8sAnnotationAppliedBy中记录的调用方作为请求用户, 为空时不识别代为提交的请求
+	ServiceAccount string
+	// 记录覆盖依赖检查、格式错误的依赖约束等事件, 为nil时不记录
+	Recorder record.EventRecorder
+}
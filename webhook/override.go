@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-logr/logr"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"time"
+)
+
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+const (
+	K8sAnnotationOverride        = "dictator.wkm.welljoint.com/override"         // 覆盖依赖检查的原因
+	K8sAnnotationOverrideExpires = "dictator.wkm.welljoint.com/override-expires" // 覆盖的过期时间, RFC3339格式
+
+	// OverrideVerb 覆盖依赖检查需要的权限, 如:
+	//
+	//	- apiGroups: ["wkm.welljoint.com"]
+	//	  resources: ["dependencystatuses"]
+	//	  verbs: ["override"]
+	OverrideVerb = "override"
+
+	EventReasonOverride = "DependenceOverridden" // 依赖检查被覆盖
+)
+
+// DefaultOverrideMaxDuration 默认的覆盖最长有效期
+const DefaultOverrideMaxDuration = 24 * time.Hour
+
+var auditLogger = logf.Log.WithName("[audit]")
+
+// Override 紧急情况下覆盖依赖检查, 由override和override-expires两个annotation声明
+type Override struct {
+	Reason  string
+	Expires time.Time
+}
+
+// GetOverride 获取对象上声明的覆盖, 未声明时返回nil, 缺少或无法解析过期时间时返回错误
+func GetOverride(obj v12.Object) (*Override, error) {
+	reason := obj.GetAnnotations()[K8sAnnotationOverride]
+	if reason == "" {
+		return nil, nil
+	}
+	raw := obj.GetAnnotations()[K8sAnnotationOverrideExpires]
+	if raw == "" {
+		return nil, fmt.Errorf("缺少覆盖的过期时间%s", K8sAnnotationOverrideExpires)
+	}
+	expires, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("覆盖的过期时间(%s)格式错误: %w", raw, err)
+	}
+	return &Override{Reason: reason, Expires: expires}, nil
+}
+
+// Active 覆盖在now时是否有效
+func (o *Override) Active(now time.Time) bool {
+	return now.Before(o.Expires)
+}
+
+// 依赖检查失败时使用对象上声明的覆盖放行, 记录事件和审计日志
+// 覆盖不存在、已过期、有效期过长或请求用户没有权限时返回依赖检查的错误
+func applyOverride(ctx context.Context, myClient client.Client, logger logr.Logger, options Options, obj runtime.Object, meta v12.Object, violation error) error {
+	override, err := GetOverride(meta)
+	if override == nil && err == nil {
+		return violation
+	}
+	maxDuration := options.OverrideMaxDuration
+	if maxDuration <= 0 {
+		maxDuration = DefaultOverrideMaxDuration
+	}
+	now := time.Now()
+	switch {
+	case err != nil:
+	case !override.Active(now):
+		err = fmt.Errorf("覆盖已于%s过期", override.Expires.Format(time.RFC3339))
+	case override.Expires.After(now.Add(maxDuration)):
+		err = fmt.Errorf("覆盖的有效期超过%s", maxDuration)
+	default:
+		err = checkOverridePermission(ctx, myClient, options, meta)
+	}
+	if err != nil {
+		logger.Info("覆盖依赖检查失败", "err", err)
+		return overrideFailed(violation, err)
+	}
+
+	var user string
+	if req, err := admission.RequestFromContext(ctx); err == nil {
+		userInfo, _ := requestUser(ctx, options, meta)
+		user = userInfo.Username
+		// 试运行时不记录事件和审计日志
+		if req.DryRun != nil && *req.DryRun {
+			logger.Info("试运行覆盖依赖检查", "user", user, "reason", override.Reason, "violation", violation.Error())
+			return nil
+		}
+	}
+	auditLogger.Info("覆盖依赖检查", "namespace", meta.GetNamespace(), "name", meta.GetName(), "user", user,
+		"reason", override.Reason, "expires", override.Expires.Format(time.RFC3339), "violation", violation.Error())
+	if options.Recorder != nil {
+		options.Recorder.Eventf(obj, corev1.EventTypeWarning, EventReasonOverride, "%s覆盖依赖检查至%s, 原因: %s, 未通过的检查: %v",
+			user, override.Expires.Format(time.RFC3339), override.Reason, violation)
+	}
+	return nil
+}
+
+// 覆盖未生效时在依赖检查的错误中附加原因, 保留其状态码和原因, 如就绪检查未通过时的429
+func overrideFailed(violation, err error) error {
+	var apiStatus apierrors.APIStatus
+	if !errors.As(violation, &apiStatus) {
+		return fmt.Errorf("%w, 覆盖未生效: %v", violation, err)
+	}
+	status := apiStatus.Status()
+	status.Message = fmt.Sprintf("%s, 覆盖未生效: %v", status.Message, err)
+	return &apierrors.StatusError{ErrStatus: status}
+}
+
+// 检查请求用户是否有覆盖依赖检查的权限
+func checkOverridePermission(ctx context.Context, myClient client.Client, options Options, meta v12.Object) error {
+	user, ok := requestUser(ctx, options, meta)
+	if !ok {
+		return errors.New("不在准入请求中, 无法获取请求用户")
+	}
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: meta.GetNamespace(),
+				Verb:      OverrideVerb,
+				Group:     wkmv1alpha1.GroupVersion.Group,
+				Resource:  "dependencystatuses",
+			},
+		},
+	}
+	if err := myClient.Create(ctx, review); err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("用户%s没有覆盖依赖检查的权限", user.Username)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 按allowed回复SubjectAccessReview的client
+type reviewClient struct {
+	client.Client
+	allowed bool
+}
+
+func (c reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if review, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		review.Status.Allowed = c.allowed
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestUseValidate_Override(t *testing.T) {
+	host := testutil.NewRegistry(t)
+	wmcImage := testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^3.0.0"})
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "", nil)
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{UserInfo: authenticationv1.UserInfo{Username: "oncall"}},
+	})
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		allowed     bool
+		wantErr     bool
+	}{
+		{name: "no override", allowed: true, wantErr: true},
+		{name: "missing expiry", annotations: map[string]string{K8sAnnotationOverride: "hotfix"}, allowed: true, wantErr: true},
+		{name: "expired", annotations: map[string]string{
+			K8sAnnotationOverride:        "hotfix",
+			K8sAnnotationOverrideExpires: now.Add(-time.Hour).Format(time.RFC3339),
+		}, allowed: true, wantErr: true},
+		{name: "too long", annotations: map[string]string{
+			K8sAnnotationOverride:        "hotfix",
+			K8sAnnotationOverrideExpires: now.Add(DefaultOverrideMaxDuration + time.Hour).Format(time.RFC3339),
+		}, allowed: true, wantErr: true},
+		{name: "forbidden", annotations: map[string]string{
+			K8sAnnotationOverride:        "hotfix",
+			K8sAnnotationOverrideExpires: now.Add(time.Hour).Format(time.RFC3339),
+		}, wantErr: true},
+		{name: "allowed", annotations: map[string]string{
+			K8sAnnotationOverride:        "hotfix",
+			K8sAnnotationOverrideExpires: now.Add(time.Hour).Format(time.RFC3339),
+		}, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(1)
+			c := reviewClient{Client: fake.NewClientBuilder().WithObjects(ocm.DeepCopy()).Build(), allowed: tt.allowed}
+			wmc := testutil.NewDeployment("wmc", wmcImage, "", nil)
+			wmc.Annotations = tt.annotations
+
+			err := UseValidate(logr.Discard(), wmc, c, Options{Recorder: recorder}, ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UseValidate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			select {
+			case e := <-recorder.Events:
+				if !strings.Contains(e, EventReasonOverride) || !strings.Contains(e, "oncall") {
+					t.Errorf("unexpected event %q", e)
+				}
+			default:
+				t.Errorf("missing %s event", EventReasonOverride)
+			}
+		})
+	}
+}
+
+func TestApplyOverride_KeepStatus(t *testing.T) {
+	wmc := testutil.NewDeployment("wmc", unreachableImage, "", nil)
+	wmc.Annotations = map[string]string{
+		K8sAnnotationOverride:        "hotfix",
+		K8sAnnotationOverrideExpires: time.Now().Add(-time.Hour).Format(time.RFC3339),
+	}
+	c := fake.NewClientBuilder().Build()
+
+	tests := []struct {
+		name      string
+		violation error
+		wantRetry bool
+	}{
+		{name: "not ready", violation: apierrors.NewTooManyRequests("依赖就绪检查未通过", 10), wantRetry: true},
+		{name: "violated", violation: errors.New("依赖检查未通过")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := applyOverride(context.Background(), c, logr.Discard(), Options{}, wmc, wmc, tt.violation)
+			if err == nil || !strings.Contains(err.Error(), "覆盖未生效") {
+				t.Fatalf("applyOverride() error = %v, want override failure", err)
+			}
+			if apierrors.IsTooManyRequests(err) != tt.wantRetry {
+				t.Errorf("applyOverride() error = %v, want retryable %v", err, tt.wantRetry)
+			}
+			if !errors.Is(err, tt.violation) && !tt.wantRetry {
+				t.Errorf("applyOverride() error = %v, want wrapping %v", err, tt.violation)
+			}
+		})
+	}
+}
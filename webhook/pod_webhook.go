@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// PodWebhook 直接创建或由非apps控制器创建的Pod的webhook, 可选启用, 只做正向依赖和能力依赖检查
+// 路径为 /validate--v1-pod, webhook配置见 deployments/dictator/bases/pod-webhook.yaml
+type PodWebhook struct {
+	client  client.Client
+	logger  logr.Logger
+	options Options
+}
+
+func (p PodWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	p.logger.Info("收到validate webhook创建请求")
+	return UsePodValidate(p.logger, obj.(*corev1.Pod), p.client, p.options, ctx)
+}
+
+func (p PodWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	p.logger.Info("收到validate webhook更新请求")
+	return UsePodValidate(p.logger, newObj.(*corev1.Pod), p.client, p.options, ctx)
+}
+
+func (p PodWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	p.logger.Info("收到validate webhook删除请求")
+	return nil
+}
+
+func SetupPodWebhookWithManager(mgr ctrl.Manager, options Options) error {
+	hook := &PodWebhook{
+		client:  mgr.GetClient(),
+		logger:  logf.Log.WithName("[webhook.pod]"),
+		options: options,
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&corev1.Pod{}).
+		WithValidator(hook).
+		Complete()
+}
+
+// UsePodValidate 对Pod进行正向依赖和能力依赖检查
+// 由StatefulSet、DaemonSet、已注册的自定义工作负载, 或由这些控制器及Deployment管理的ReplicaSet所管理的Pod
+// 已在其控制器上检查过, 直接跳过; 单独创建的ReplicaSet没有经过检查, 其管理的Pod仍需检查
+func UsePodValidate(logger logr.Logger, pod *corev1.Pod, myClient client.Client, options Options, ctx context.Context) error {
+	if IsExempt(ctx, myClient, logger, options, pod) {
+		return nil
+	}
+	if owner := v12.GetControllerOf(pod); owner != nil && isCheckedOwner(ctx, myClient, logger, pod.Namespace, owner) {
+		logger.V(1).Info("Pod由已检查的控制器管理, 跳过", "pod", pod.Name, "owner", owner.Kind+"/"+owner.Name)
+		return nil
+	}
+	if err := checkPod(logger, pod, myClient, options, ctx); err != nil {
+		return applyOverride(ctx, myClient, logger, options, pod, pod, err)
+	}
+	return nil
+}
+
+func checkPod(logger logr.Logger, pod *corev1.Pod, myClient client.Client, options Options, ctx context.Context) error {
+	objsMap, err := ListWorkloads(ctx, myClient, logger, pod.Namespace)
+	if err != nil {
+		return err
+	}
+	_, deps, err := registry.GetVersionAndDependence(corev1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}, options.DefaultPlatform)
+	if err != nil {
+		logger.Info("获取版本和依赖失败", "err", err)
+		return err
+	}
+	userDeps := registry.GetObjUserDependence(pod)
+	if err = registry.ValidateUserDependence(userDeps, deps.Constraints()); err != nil {
+		logger.Info("检测用户声明的依赖约束失败", "err", err)
+		return err
+	}
+	if err = registry.CheckForwardDependence(objsMap, deps.Constraints()); err == nil {
+		err = registry.CheckUserForwardDependence(objsMap, userDeps)
+	}
+	if err != nil {
+		logger.Info("检测正向依赖失败", "err", err)
+		return err
+	}
+	capabilityObjs := make(map[string]v12.Object, len(objsMap))
+	for k, v := range objsMap {
+		if w, ok := registry.GetWorkload(v); ok {
+			capabilityObjs[k] = w.Meta
+		}
+	}
+	if _, err = registry.ResolveCapabilities(capabilityObjs, deps.Constraints(), userDeps); err != nil {
+		logger.Info("检测能力依赖失败", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 判断Pod的控制器是否已经过依赖检查
+// ReplicaSet本身没有webhook, 只有由已检查的控制器(如Deployment)管理时才视为已检查
+func isCheckedOwner(ctx context.Context, myClient client.Client, logger logr.Logger, namespace string, owner *v12.OwnerReference) bool {
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	if err != nil {
+		return false
+	}
+	if gv.Group == "apps" {
+		switch owner.Kind {
+		case "StatefulSet", "DaemonSet":
+			return true
+		case "ReplicaSet":
+			rs := &appsv1.ReplicaSet{}
+			if err = myClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: owner.Name}, rs); err != nil {
+				logger.Info("获取Pod所属的ReplicaSet失败", "replicaset", owner.Name, "err", err)
+				return false
+			}
+			rsOwner := v12.GetControllerOf(rs)
+			return rsOwner != nil && isCheckedReplicaSetOwner(rsOwner)
+		}
+	}
+	return isRegisteredOwner(gv, owner.Kind)
+}
+
+// 判断ReplicaSet的控制器是否已经过依赖检查, 如Deployment或管理ReplicaSet的Argo Rollout
+func isCheckedReplicaSetOwner(owner *v12.OwnerReference) bool {
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	if err != nil {
+		return false
+	}
+	if gv.Group == "apps" && owner.Kind == "Deployment" {
+		return true
+	}
+	return isRegisteredOwner(gv, owner.Kind)
+}
+
+// 判断控制器是否为已注册的自定义工作负载
+func isRegisteredOwner(gv schema.GroupVersion, kind string) bool {
+	for _, k := range registry.WorkloadKinds() {
+		if k.Group == gv.Group && k.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
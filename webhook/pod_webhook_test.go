@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func TestUsePodValidate(t *testing.T) {
+	host := testutil.NewRegistry(t)
+	image := testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^2.0.0"})
+	capImage := testutil.PushImage(t, host, "wecloud/cms:4.0.0", map[string]string{"ver_cap_ocm.api": "required:^3.0"})
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:1.9.0", "", nil)
+	registry.SetObjCapability(ocm, map[string]string{"ocm.api": "2.3.0"})
+	isController := true
+	managed := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       "default",
+		Name:            "wmc-5d4f",
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "wmc", Controller: &isController}},
+	}}
+	bare := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "wmc-bare"}}
+	c := fake.NewClientBuilder().WithObjects(ocm, managed, bare).Build()
+
+	newPod := func(owner *metav1.OwnerReference, image string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "wmc"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "wmc", Image: image}}},
+		}
+		if owner != nil {
+			owner.Controller = &isController
+			pod.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return pod
+	}
+	withUserDependence := func(pod *corev1.Pod, key, constraint string) *corev1.Pod {
+		pod.Annotations = map[string]string{registry.UserDependenceAnnotation(key): constraint}
+		return pod
+	}
+
+	registry.RegisterWorkloadKind(registry.WorkloadKind{Group: "apps.kruise.io", Version: "v1alpha1", Kind: "CloneSet"})
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		wantErr bool
+	}{
+		{name: "ownerless", pod: newPod(nil, image), wantErr: true},
+		{name: "job", pod: newPod(&metav1.OwnerReference{APIVersion: "batch/v1", Kind: "Job", Name: "wmc"}, image), wantErr: true},
+		{name: "replicaset", pod: newPod(&metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "wmc-5d4f"}, "unreachable:5000/wmc:1.8.1")},
+		// 单独创建的ReplicaSet没有经过检查
+		{name: "bare replicaset", pod: newPod(&metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "wmc-bare"}, image), wantErr: true},
+		{name: "missing replicaset", pod: newPod(&metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "wmc-gone"}, image), wantErr: true},
+		{name: "cloneset", pod: newPod(&metav1.OwnerReference{APIVersion: "apps.kruise.io/v1alpha1", Kind: "CloneSet", Name: "wmc"}, "unreachable:5000/wmc:1.8.1")},
+		{name: "no dependence", pod: newPod(nil, "busybox")},
+		{name: "image capability unsatisfied", pod: newPod(nil, capImage), wantErr: true},
+		{name: "user capability satisfied", pod: withUserDependence(newPod(nil, "busybox"), "cap_ocm.api", "^2.0")},
+		{name: "user capability unsatisfied", pod: withUserDependence(newPod(nil, "busybox"), "cap_ocm.api", "^3.0"), wantErr: true},
+		{name: "user dependence unsatisfied", pod: withUserDependence(newPod(nil, "busybox"), "ocm", "^2.0.0"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := UsePodValidate(logr.Discard(), tt.pod, c, Options{}, context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("UsePodValidate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ReadinessRetryAfterSeconds 依赖就绪检查未通过时建议的重试间隔
+const ReadinessRetryAfterSeconds = 10
+
+// ReadinessRequirement 正向依赖检查时对被依赖服务就绪副本的要求, 均为0时不检查
+type ReadinessRequirement struct {
+	MinReady        int // 至少N个运行符合约束版本的就绪副本
+	MinReadyPercent int // 至少百分之N的期望副本运行符合约束的版本且已就绪
+}
+
+func (r ReadinessRequirement) Enabled() bool {
+	return r.MinReady > 0 || r.MinReadyPercent > 0
+}
+
+// 期望副本数为desired时需要的就绪副本数, 不超过期望副本数
+func (r ReadinessRequirement) required(desired int) int {
+	required := r.MinReady
+	if byPercent := (desired*r.MinReadyPercent + 99) / 100; byPercent > required {
+		required = byPercent
+	}
+	if required > desired {
+		required = desired
+	}
+	return required
+}
+
+// 检查被依赖服务运行符合约束版本的就绪副本数, 不满足时返回429, 调用方可稍后重试
+func (r ReadinessRequirement) check(live *liveObjects, objs map[string]runtime.Object, deps map[string]string) error {
+	for svc, constraint := range deps {
+		obj := objs[svc]
+		if obj == nil {
+			continue
+		}
+		desired := desiredReplicas(obj)
+		if desired == 0 {
+			continue
+		}
+		requirement, err := registry.ParseRequirement(constraint)
+		if err != nil {
+			return err
+		}
+
+		ready := 0
+		for _, pod := range live.workloadPods(obj) {
+			if !isPodReady(pod) {
+				continue
+			}
+			v, err := semver.NewVersion(registry.GetPodVersion(pod))
+			if err == nil && requirement.Matches(v) && requirement.Conflict(v) == "" {
+				ready++
+			}
+		}
+		if required := r.required(desired); ready < required {
+			return apierrors.NewTooManyRequests(fmt.Sprintf("依赖就绪检查未通过，%s运行符合依赖约束(%s)版本的就绪副本数为%d，至少需要%d，请稍后重试",
+				svc, constraint, ready, required), ReadinessRetryAfterSeconds)
+		}
+	}
+	return nil
+}
+
+// 获取工作负载的期望副本数, 未声明时按1处理
+func desiredReplicas(obj runtime.Object) int {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		if o.Spec.Replicas != nil {
+			return int(*o.Spec.Replicas)
+		}
+	case *appsv1.StatefulSet:
+		if o.Spec.Replicas != nil {
+			return int(*o.Spec.Replicas)
+		}
+	case *appsv1.DaemonSet:
+		return int(o.Status.DesiredNumberScheduled)
+	case *unstructured.Unstructured:
+		if replicas, found, err := unstructured.NestedInt64(o.Object, "spec", "replicas"); err == nil && found {
+			return int(replicas)
+		}
+	}
+	return 1
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
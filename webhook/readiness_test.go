@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func TestReadinessRequirement_required(t *testing.T) {
+	tests := []struct {
+		requirement ReadinessRequirement
+		desired     int
+		want        int
+	}{
+		{requirement: ReadinessRequirement{MinReady: 1}, desired: 3, want: 1},
+		{requirement: ReadinessRequirement{MinReady: 2}, desired: 1, want: 1},
+		{requirement: ReadinessRequirement{MinReadyPercent: 50}, desired: 3, want: 2},
+		{requirement: ReadinessRequirement{MinReady: 1, MinReadyPercent: 100}, desired: 4, want: 4},
+	}
+	for _, tt := range tests {
+		if got := tt.requirement.required(tt.desired); got != tt.want {
+			t.Errorf("required(%d) with %+v got = %v, want %v", tt.desired, tt.requirement, got, tt.want)
+		}
+	}
+}
+
+func TestUseValidate_Readiness(t *testing.T) {
+	host := testutil.NewRegistry(t)
+	wmc := testutil.NewDeployment("wmc", testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^2.0.0"}), "", nil)
+
+	replicas := int32(2)
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "", nil)
+	ocm.Spec.Replicas = &replicas
+	isController := true
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "ocm-new",
+			UID:             "ocm-new-uid",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "ocm", UID: ocm.UID, Controller: &isController}},
+		},
+		Spec:   appsv1.ReplicaSetSpec{Template: ocm.Spec.Template},
+		Status: appsv1.ReplicaSetStatus{Replicas: 2},
+	}
+	newPod := func(name, image string, ready bool) *corev1.Pod {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "default",
+				Name:            name,
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &isController}},
+			},
+			Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "ocm", Image: image}}},
+			Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		requirement ReadinessRequirement
+		pods        []client.Object
+		wantErr     bool
+	}{
+		{name: "disabled", pods: []client.Object{newPod("ocm-1", "harbor:5000/wecloud/ocm:2.3.0", false)}},
+		{name: "not ready", requirement: ReadinessRequirement{MinReady: 1}, pods: []client.Object{
+			newPod("ocm-1", "harbor:5000/wecloud/ocm:2.3.0", false),
+			newPod("ocm-2", "harbor:5000/wecloud/ocm:1.9.0", true),
+		}, wantErr: true},
+		{name: "one ready", requirement: ReadinessRequirement{MinReady: 1}, pods: []client.Object{
+			newPod("ocm-1", "harbor:5000/wecloud/ocm:2.3.0", true),
+			newPod("ocm-2", "harbor:5000/wecloud/ocm:2.3.0", false),
+		}},
+		{name: "percent", requirement: ReadinessRequirement{MinReadyPercent: 100}, pods: []client.Object{
+			newPod("ocm-1", "harbor:5000/wecloud/ocm:2.3.0", true),
+			newPod("ocm-2", "harbor:5000/wecloud/ocm:2.3.0", false),
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := append([]client.Object{ocm.DeepCopy(), rs.DeepCopy()}, tt.pods...)
+			c := fake.NewClientBuilder().WithObjects(objs...).Build()
+			err := UseValidate(logr.Discard(), wmc.DeepCopy(), c, Options{Readiness: tt.requirement}, context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UseValidate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !apierrors.IsTooManyRequests(err) {
+				t.Errorf("UseValidate() error = %v, want retryable", err)
+			}
+		})
+	}
+}
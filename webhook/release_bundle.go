@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=wkm.welljoint.com,resources=releasebundles,verbs=get;list;watch
+
+// 检查服务版本是否与命名空间绑定的发布包一致, 命名空间未绑定发布包时不检查
+func checkReleaseBundle(ctx context.Context, myClient client.Client, namespace, svc, version string) error {
+	var ns corev1.Namespace
+	if err := myClient.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("获取命名空间%s失败: %w", namespace, err)
+	}
+	name := ns.Labels[wkmv1alpha1.LabelReleaseBundle]
+	if name == "" {
+		return nil
+	}
+
+	var bundle wkmv1alpha1.ReleaseBundle
+	if err := myClient.Get(ctx, client.ObjectKey{Name: name}, &bundle); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("发布包检查失败，命名空间%s绑定的发布包%s不存在", namespace, name)
+		}
+		return err
+	}
+	pinned, ok := bundle.Spec.Services[svc]
+	if !ok {
+		if bundle.Spec.Strict {
+			return fmt.Errorf("发布包检查失败，发布包%s中没有%s", name, svc)
+		}
+		return nil
+	}
+
+	want, err := semver.NewVersion(pinned)
+	if err != nil {
+		return fmt.Errorf("发布包%s中%s的版本(%s)格式错误: %w", name, svc, pinned, err)
+	}
+	if got, err := semver.NewVersion(version); err != nil || !got.Equal(want) {
+		return fmt.Errorf("发布包检查失败，%s版本(%s)与发布包%s中的版本(%s)不一致", svc, version, name, pinned)
+	}
+	return nil
+}
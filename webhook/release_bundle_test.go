@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	wkmv1alpha1 "gitlab.wellcloud.cc/cloud/dictator/api/v1alpha1"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+func TestCheckReleaseBundle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := wkmv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	bind := func(name, bundle string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{wkmv1alpha1.LabelReleaseBundle: bundle}}}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		bind("qa", "release"),
+		bind("prod", "release-strict"),
+		bind("broken", "missing"),
+		&wkmv1alpha1.ReleaseBundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "release"},
+			Spec:       wkmv1alpha1.ReleaseBundleSpec{Services: map[string]string{"wmc": "1.8.1", "ocm": "2.3.0"}},
+		},
+		&wkmv1alpha1.ReleaseBundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "release-strict"},
+			Spec:       wkmv1alpha1.ReleaseBundleSpec{Services: map[string]string{"wmc": "1.8.1"}, Strict: true},
+		},
+	).Build()
+
+	tests := []struct {
+		name      string
+		namespace string
+		svc       string
+		version   string
+		wantErr   bool
+	}{
+		{name: "unbound", namespace: "default", svc: "wmc", version: "1.9.0"},
+		{name: "pinned", namespace: "qa", svc: "wmc", version: "1.8.1"},
+		{name: "outside bundle", namespace: "qa", svc: "ocm", version: "2.4.0", wantErr: true},
+		{name: "not listed", namespace: "qa", svc: "cms", version: "4.1.2"},
+		{name: "not listed strict", namespace: "prod", svc: "cms", version: "4.1.2", wantErr: true},
+		{name: "missing bundle", namespace: "broken", svc: "wmc", version: "1.8.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkReleaseBundle(context.Background(), c, tt.namespace, tt.svc, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkReleaseBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUseValidateUpdate_ReleaseBundle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := wkmv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	wmc := testutil.NewDeployment("wmc", unreachableImage, "1.8.1", nil)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testutil.Namespace, Labels: map[string]string{wkmv1alpha1.LabelReleaseBundle: "release"}}},
+		&wkmv1alpha1.ReleaseBundle{
+			ObjectMeta: metav1.ObjectMeta{Name: "release"},
+			Spec:       wkmv1alpha1.ReleaseBundleSpec{Services: map[string]string{"wmc": "1.9.0"}},
+		},
+		wmc,
+	).Build()
+
+	// 镜像和依赖约束均未变化, 但当前版本不在命名空间之后绑定的发布包中
+	scaled := wmc.DeepCopy()
+	replicas := int32(3)
+	scaled.Spec.Replicas = &replicas
+	err := UseValidateUpdate(logr.Discard(), wmc, scaled, c, Options{}, context.Background())
+	if err == nil || !strings.Contains(err.Error(), "发布包检查失败") {
+		t.Errorf("UseValidateUpdate() error = %v, want release bundle violation", err)
+	}
+}
@@ -11,21 +11,22 @@ import (
 )
 
 //+kubebuilder:webhook:path=/mutate-apps-v1-statefulset,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps,resources=statefulsets,verbs=create;update,versions=v1,name=mstatefulset.kb.io,admissionReviewVersions=v1
-//+kubebuilder:webhook:path=/validate-apps-v1-statefulset,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps,resources=statefulsets,verbs=create;update,versions=v1,name=vstatefulset.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-apps-v1-statefulset,mutating=false,failurePolicy=fail,sideEffects=NoneOnDryRun,groups=apps,resources=statefulsets,verbs=create;update,versions=v1,name=vstatefulset.kb.io,admissionReviewVersions=v1
 
 type StatefulSetWebhook struct {
-	client client.Client
-	logger logr.Logger
+	client  client.Client
+	logger  logr.Logger
+	options Options
 }
 
 func (s StatefulSetWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
 	s.logger.Info("收到validate webhook创建请求")
-	return UseValidate(s.logger, obj, s.client, ctx)
+	return UseValidate(s.logger, obj, s.client, s.options, ctx)
 }
 
 func (s StatefulSetWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
 	s.logger.Info("收到validate webhook更新请求")
-	return UseValidate(s.logger, newObj, s.client, ctx)
+	return UseValidateUpdate(s.logger, oldObj, newObj, s.client, s.options, ctx)
 }
 
 func (s StatefulSetWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) error {
@@ -34,17 +35,20 @@ func (s StatefulSetWebhook) ValidateDelete(ctx context.Context, obj runtime.Obje
 }
 
 func (s StatefulSetWebhook) Default(ctx context.Context, obj runtime.Object) error {
-	return UseDefault(obj, s.logger)
+	return defaultWorkload(ctx, s.client, s.logger, s.options, obj)
 }
 
-func SetupStatefulSetWebhookWithManager(mgr ctrl.Manager) error {
+func SetupStatefulSetWebhookWithManager(mgr ctrl.Manager, options Options) error {
 	hook := &StatefulSetWebhook{
-		client: mgr.GetClient(),
-		logger: logf.Log.WithName("[webhook.statefulset]"),
+		client:  mgr.GetClient(),
+		logger:  logf.Log.WithName("[webhook.statefulset]"),
+		options: options,
 	}
-	return ctrl.NewWebhookManagedBy(mgr).
+	if err := ctrl.NewWebhookManagedBy(mgr).
 		For(&appsv1.StatefulSet{}).
 		WithDefaulter(hook).
-		WithValidator(hook).
-		Complete()
+		Complete(); err != nil {
+		return err
+	}
+	return registerValidator(mgr, &appsv1.StatefulSet{}, hook)
 }
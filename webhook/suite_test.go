@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"path/filepath"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"testing"
+	"time"
+)
+
+func TestUseDefault(t *testing.T) {
+	host := testutil.NewRegistry(t)
+	image := testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^2.0.0"})
+
+	tests := []struct {
+		name string
+		obj  client.Object
+	}{
+		{name: "deployment", obj: testutil.NewDeployment("wmc", image, "", nil)},
+		{name: "statefulset", obj: &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "wmc", Labels: map[string]string{"app": "wmc"}},
+			Spec:       appsv1.StatefulSetSpec{Template: testutil.NewDeployment("wmc", image, "", nil).Spec.Template},
+		}},
+		{name: "daemonset", obj: &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "wmc", Annotations: map[string]string{"cms" + K8sAnnotationDependence: "^4.0.0"}},
+			Spec:       appsv1.DaemonSetSpec{Template: testutil.NewDeployment("wmc", image, "", nil).Spec.Template},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := UseDefault(tt.obj, logr.Discard(), Options{}); err != nil {
+				t.Fatalf("UseDefault() error = %v", err)
+			}
+			if got := tt.obj.GetLabels()[registry.K8sLabelVersion]; got != "1.8.1" {
+				t.Errorf("UseDefault() version label = %q, want %q", got, "1.8.1")
+			}
+			if got := tt.obj.GetAnnotations()["ocm"+K8sAnnotationDependence]; got != "^2.0.0" {
+				t.Errorf("UseDefault() ocm dependence = %q, want %q", got, "^2.0.0")
+			}
+			if got, ok := tt.obj.GetAnnotations()["cms"+K8sAnnotationDependence]; ok {
+				t.Errorf("UseDefault() stale cms dependence = %q", got)
+			}
+		})
+	}
+}
+
+// 启动envtest并注册webhook, 需要通过KUBEBUILDER_ASSETS指定kube-apiserver和etcd, 如 make test
+func startTestEnv(t *testing.T) client.Client {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS未设置, 跳过envtest")
+	}
+
+	testEnv := &envtest.Environment{
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			Paths: []string{filepath.Join("..", "config", "webhook")},
+		},
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = testEnv.Stop() })
+
+	scheme := runtime.NewScheme()
+	if err = clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	options := &testEnv.WebhookInstallOptions
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme,
+		Host:               options.LocalServingHost,
+		Port:               options.LocalServingPort,
+		CertDir:            options.LocalServingCertDir,
+		MetricsBindAddress: "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, setup := range []func(ctrl.Manager, Options) error{
+		SetupDeploymentWebhookWithManager,
+		SetupStatefulSetWebhookWithManager,
+		SetupDaemonSetWebhookWithManager,
+	} {
+		if err = setup(mgr, Options{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		if err := mgr.Start(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	if !mgr.GetCache().WaitForCacheSync(ctx) {
+		t.Fatal("等待缓存同步失败")
+	}
+	// 等待webhook服务就绪
+	checker := mgr.GetWebhookServer().StartedChecker()
+	for i := 0; i < 50 && checker(nil) != nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestWebhookPersistsMetadata(t *testing.T) {
+	c := startTestEnv(t)
+	ctx := context.Background()
+	host := testutil.NewRegistry(t)
+	ocm := testutil.PushImage(t, host, "wecloud/ocm:2.3.0", nil)
+	wmc := testutil.PushImage(t, host, "wecloud/wmc:1.8.1", map[string]string{"ver_ocm": "^2.0.0"})
+	wmcNext := testutil.PushImage(t, host, "wecloud/wmc:1.9.0", nil)
+
+	if err := c.Create(ctx, testutil.NewDeployment("ocm", ocm, "", nil)); err != nil {
+		t.Fatalf("创建ocm失败: %v", err)
+	}
+	if err := c.Create(ctx, testutil.NewDeployment("wmc", wmc, "", nil)); err != nil {
+		t.Fatalf("创建wmc失败: %v", err)
+	}
+
+	var got appsv1.Deployment
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "wmc"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Labels[registry.K8sLabelVersion]; v != "1.8.1" {
+		t.Errorf("存储的版本label = %q, want %q", v, "1.8.1")
+	}
+	if dep := got.Annotations["ocm"+K8sAnnotationDependence]; dep != "^2.0.0" {
+		t.Errorf("存储的依赖约束 = %q, want %q", dep, "^2.0.0")
+	}
+
+	// 新镜像不再依赖ocm, 依赖约束应被移除
+	got.Spec.Template.Spec.Containers[0].Image = wmcNext
+	if err := c.Update(ctx, &got); err != nil {
+		t.Fatalf("更新wmc失败: %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "wmc"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Labels[registry.K8sLabelVersion]; v != "1.9.0" {
+		t.Errorf("更新后的版本label = %q, want %q", v, "1.9.0")
+	}
+	if dep, ok := got.Annotations["ocm"+K8sAnnotationDependence]; ok {
+		t.Errorf("更新后仍存在依赖约束 %q", dep)
+	}
+}
@@ -0,0 +1,174 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"testing"
+)
+
+// 镜像仓库不可访问, 访问镜像仓库时返回错误
+const unreachableImage = "127.0.0.1:1/wecloud/wmc:1.8.1"
+
+func TestUseValidateUpdate(t *testing.T) {
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "", nil)
+	c := fake.NewClientBuilder().WithObjects(ocm).Build()
+	oldObj := testutil.NewDeployment("wmc", unreachableImage, "1.8.1", map[string]string{"ocm": "^2.0.0"})
+
+	scaled := oldObj.DeepCopy()
+	replicas := int32(3)
+	scaled.Spec.Replicas = &replicas
+
+	constrained := oldObj.DeepCopy()
+	constrained.Annotations["ocm"+K8sAnnotationDependence] = "^3.0.0"
+
+	userConstrained := oldObj.DeepCopy()
+	userConstrained.Annotations["ocm"+registry.K8sAnnotationUserDependence] = ">=2.4.0"
+
+	userMalformed := oldObj.DeepCopy()
+	userMalformed.Annotations["ocm"+registry.K8sAnnotationUserDependence] = "latest"
+
+	upgraded := testutil.NewDeployment("wmc", "127.0.0.1:1/wecloud/wmc:1.9.0", "1.8.1", map[string]string{"ocm": "^2.0.0"})
+
+	tests := []struct {
+		name    string
+		newObj  *appsv1.Deployment
+		wantErr bool
+	}{
+		{name: "scaled", newObj: scaled},
+		{name: "constraint changed", newObj: constrained, wantErr: true},
+		{name: "user constraint added", newObj: userConstrained, wantErr: true},
+		{name: "user constraint malformed", newObj: userMalformed, wantErr: true},
+		{name: "image changed", newObj: upgraded, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := UseValidateUpdate(logr.Discard(), oldObj, tt.newObj, c, Options{}, context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UseValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultWorkload_ReuseVersion(t *testing.T) {
+	oldObj := testutil.NewDeployment("wmc", unreachableImage, "1.8.1", map[string]string{"ocm": "^2.0.0"})
+	raw, err := json.Marshal(oldObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Update, OldObject: runtime.RawExtension{Raw: raw}},
+	})
+	c := fake.NewClientBuilder().Build()
+
+	// kubectl apply 覆盖了label和annotation, 镜像未变化
+	obj := testutil.NewDeployment("wmc", unreachableImage, "", nil)
+	if err = defaultWorkload(ctx, c, logr.Discard(), Options{}, obj); err != nil {
+		t.Fatalf("defaultWorkload() error = %v", err)
+	}
+	if got := obj.Labels[registry.K8sLabelVersion]; got != "1.8.1" {
+		t.Errorf("version label = %q, want %q", got, "1.8.1")
+	}
+	if got := obj.Annotations["ocm"+K8sAnnotationDependence]; got != "^2.0.0" {
+		t.Errorf("ocm dependence = %q, want %q", got, "^2.0.0")
+	}
+
+	// 镜像变化时需要访问镜像仓库
+	obj = testutil.NewDeployment("wmc", "127.0.0.1:1/wecloud/wmc:1.9.0", "", nil)
+	if err = defaultWorkload(ctx, c, logr.Discard(), Options{}, obj); err == nil {
+		t.Errorf("defaultWorkload() with changed image error = nil, want registry error")
+	}
+}
+
+func TestUseValidateUpdate_Capability(t *testing.T) {
+	ocm := testutil.NewDeployment("ocm", "127.0.0.1:1/wecloud/ocm:2.3.0", "", nil)
+	registry.SetObjVersion(ocm, "2.3.0", nil)
+	registry.SetObjCapability(ocm, map[string]string{"ocm.api": "2.3.0"})
+	wmc := testutil.NewDeployment("wmc", unreachableImage, "1.8.1", map[string]string{"cap_ocm.api": "required:^2.0"})
+	c := fake.NewClientBuilder().WithObjects(ocm, wmc).Build()
+
+	requireV3 := wmc.DeepCopy()
+	requireV3.Annotations["ocm.api"+registry.K8sAnnotationCapabilityDependence] = "required:^3.0"
+
+	userRequireV3 := wmc.DeepCopy()
+	userRequireV3.Annotations["ocm.api"+registry.K8sAnnotationUserCapabilityDependence] = "^3.0"
+
+	compatible := ocm.DeepCopy()
+	registry.SetObjCapability(compatible, map[string]string{"ocm.api": "2.4.0"})
+
+	breaking := ocm.DeepCopy()
+	registry.SetObjCapability(breaking, map[string]string{"ocm.api": "3.0.0"})
+
+	removed := ocm.DeepCopy()
+	registry.SetObjCapability(removed, nil)
+
+	tests := []struct {
+		name    string
+		oldObj  *appsv1.Deployment
+		newObj  *appsv1.Deployment
+		wantErr bool
+	}{
+		{name: "requirement unsatisfied", oldObj: wmc, newObj: requireV3, wantErr: true},
+		{name: "user requirement unsatisfied", oldObj: wmc, newObj: userRequireV3, wantErr: true},
+		{name: "provider compatible", oldObj: ocm, newObj: compatible},
+		{name: "provider breaking", oldObj: ocm, newObj: breaking, wantErr: true},
+		{name: "provider removed", oldObj: ocm, newObj: removed, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := UseValidateUpdate(logr.Discard(), tt.oldObj, tt.newObj, c, Options{}, context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UseValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultWorkload_DependenceEnv(t *testing.T) {
+	ocm := testutil.NewDeployment("ocm", "127.0.0.1:1/wecloud/ocm:2.3.0", "", nil)
+	registry.SetObjVersion(ocm, "2.3.0", nil)
+	c := fake.NewClientBuilder().WithObjects(ocm).Build()
+
+	oldObj := testutil.NewDeployment("wmc", unreachableImage, "1.8.1", map[string]string{"ocm": "^2.0.0", "cms": "^4.0.0"})
+	raw, err := json.Marshal(oldObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Update, OldObject: runtime.RawExtension{Raw: raw}},
+	})
+
+	tests := []struct {
+		name    string
+		optIn   bool
+		wantEnv []corev1.EnvVar
+	}{
+		{name: "opt out"},
+		// cms不存在, 不注入
+		{name: "opt in", optIn: true, wantEnv: []corev1.EnvVar{{Name: "WKM_DEP_OCM_VERSION", Value: "2.3.0"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := oldObj.DeepCopy()
+			if tt.optIn {
+				obj.Annotations[K8sAnnotationInjectDependenceEnv] = "true"
+			}
+			if err := defaultWorkload(ctx, c, logr.Discard(), Options{}, obj); err != nil {
+				t.Fatalf("defaultWorkload() error = %v", err)
+			}
+			if got := obj.Spec.Template.Spec.Containers[0].Env; !reflect.DeepEqual(got, tt.wantEnv) {
+				t.Errorf("env = %v, want %v", got, tt.wantEnv)
+			}
+		})
+	}
+}
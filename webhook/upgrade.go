@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+// UpgradePolicy 版本升级策略, 更新工作负载时比较新旧版本
+type UpgradePolicy struct {
+	// 禁止降级
+	ForbidDowngrade bool `json:"forbidDowngrade,omitempty"`
+	// 一次更新允许跨越的最大主版本数, 0为不限制
+	MaxMajorJump uint64 `json:"maxMajorJump,omitempty"`
+	// 必经版本, 跨越必经版本升级时需先升级到该版本
+	Waypoints []string `json:"waypoints,omitempty"`
+}
+
+// UpgradePolicyConfig 版本升级策略配置, 优先使用"命名空间/服务"的策略, 其次为服务、命名空间, 最后为默认策略, 格式如:
+//
+//	default:
+//	  forbidDowngrade: true
+//	namespaces:
+//	  prod:
+//	    forbidDowngrade: true
+//	    maxMajorJump: 1
+//	services:
+//	  ocm:
+//	    waypoints: ["2.0.0"]
+//	  test/ocm: {}
+type UpgradePolicyConfig struct {
+	Default    *UpgradePolicy           `json:"default,omitempty"`
+	Namespaces map[string]UpgradePolicy `json:"namespaces,omitempty"`
+	Services   map[string]UpgradePolicy `json:"services,omitempty"`
+}
+
+// LoadUpgradePolicyConfig 从配置文件中读取版本升级策略
+func LoadUpgradePolicyConfig(path string) (UpgradePolicyConfig, error) {
+	var cfg UpgradePolicyConfig
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err = yaml.UnmarshalStrict(raw, &cfg); err != nil {
+		return cfg, err
+	}
+	policies := make([]UpgradePolicy, 0, len(cfg.Namespaces)+len(cfg.Services)+1)
+	if cfg.Default != nil {
+		policies = append(policies, *cfg.Default)
+	}
+	for _, p := range cfg.Namespaces {
+		policies = append(policies, p)
+	}
+	for _, p := range cfg.Services {
+		policies = append(policies, p)
+	}
+	for _, p := range policies {
+		for _, w := range p.Waypoints {
+			if _, err = semver.NewVersion(w); err != nil {
+				return cfg, fmt.Errorf("必经版本(%s)格式错误: %w", w, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// 获取服务适用的升级策略, 没有适用的策略时返回nil
+func (c UpgradePolicyConfig) policy(namespace, svc string) *UpgradePolicy {
+	if p, ok := c.Services[namespace+"/"+svc]; ok {
+		return &p
+	}
+	if p, ok := c.Services[svc]; ok {
+		return &p
+	}
+	if p, ok := c.Namespaces[namespace]; ok {
+		return &p
+	}
+	return c.Default
+}
+
+// 检查服务从oldVersion更新到newVersion是否符合升级策略, 版本为空或无法解析时不检查
+func (c UpgradePolicyConfig) check(namespace, svc, oldVersion, newVersion string) error {
+	p := c.policy(namespace, svc)
+	if p == nil {
+		return nil
+	}
+	return p.Check(svc, oldVersion, newVersion)
+}
+
+// Check 检查服务从oldVersion更新到newVersion是否符合升级策略, 版本为空或无法解析时不检查
+func (p UpgradePolicy) Check(svc, oldVersion, newVersion string) error {
+	from, err := semver.NewVersion(oldVersion)
+	if err != nil {
+		return nil
+	}
+	to, err := semver.NewVersion(newVersion)
+	if err != nil {
+		return nil
+	}
+
+	if p.ForbidDowngrade && to.LessThan(from) {
+		return fmt.Errorf("升级策略检查失败，禁止%s从%s降级到%s", svc, oldVersion, newVersion)
+	}
+	if p.MaxMajorJump > 0 && to.Major() > from.Major() && to.Major()-from.Major() > p.MaxMajorJump {
+		return fmt.Errorf("升级策略检查失败，%s从%s升级到%s跨越的主版本数超过%d", svc, oldVersion, newVersion, p.MaxMajorJump)
+	}
+	for _, raw := range p.Waypoints {
+		w, err := semver.NewVersion(raw)
+		if err != nil {
+			continue
+		}
+		if from.LessThan(w) && to.GreaterThan(w) {
+			return fmt.Errorf("升级策略检查失败，%s从%s升级到%s前需先升级到必经版本%s", svc, oldVersion, newVersion, raw)
+		}
+	}
+	return nil
+}
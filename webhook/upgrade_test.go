@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func TestUpgradePolicy_Check(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     UpgradePolicy
+		oldVersion string
+		newVersion string
+		wantErr    bool
+	}{
+		{name: "downgrade allowed", oldVersion: "2.4.0", newVersion: "1.9.0"},
+		{name: "downgrade", policy: UpgradePolicy{ForbidDowngrade: true}, oldVersion: "2.4.0", newVersion: "1.9.0", wantErr: true},
+		{name: "upgrade", policy: UpgradePolicy{ForbidDowngrade: true}, oldVersion: "2.4.0", newVersion: "2.4.1"},
+		{name: "unknown version", policy: UpgradePolicy{ForbidDowngrade: true}, oldVersion: "", newVersion: "1.9.0"},
+		{name: "major jump", policy: UpgradePolicy{MaxMajorJump: 1}, oldVersion: "1.9.0", newVersion: "3.0.0", wantErr: true},
+		{name: "one major", policy: UpgradePolicy{MaxMajorJump: 1}, oldVersion: "1.9.0", newVersion: "2.5.0"},
+		{name: "skip waypoint", policy: UpgradePolicy{Waypoints: []string{"2.0.0"}}, oldVersion: "1.9.0", newVersion: "2.1.0", wantErr: true},
+		{name: "to waypoint", policy: UpgradePolicy{Waypoints: []string{"2.0.0"}}, oldVersion: "1.9.0", newVersion: "2.0.0"},
+		{name: "from waypoint", policy: UpgradePolicy{Waypoints: []string{"2.0.0"}}, oldVersion: "2.0.0", newVersion: "2.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Check("ocm", tt.oldVersion, tt.newVersion); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadUpgradePolicyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upgrade-policy.yaml")
+	raw := `
+default:
+  forbidDowngrade: true
+namespaces:
+  prod:
+    maxMajorJump: 1
+services:
+  ocm:
+    waypoints: ["2.0.0"]
+  test/ocm: {}
+`
+	if err := os.WriteFile(path, []byte(raw), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadUpgradePolicyConfig(path)
+	if err != nil {
+		t.Fatalf("LoadUpgradePolicyConfig() error = %v", err)
+	}
+	tests := []struct {
+		namespace string
+		svc       string
+		want      UpgradePolicy
+	}{
+		{namespace: "test", svc: "ocm", want: UpgradePolicy{}},
+		{namespace: "prod", svc: "ocm", want: UpgradePolicy{Waypoints: []string{"2.0.0"}}},
+		{namespace: "prod", svc: "wmc", want: UpgradePolicy{MaxMajorJump: 1}},
+		{namespace: "dev", svc: "wmc", want: UpgradePolicy{ForbidDowngrade: true}},
+	}
+	for _, tt := range tests {
+		if got := cfg.policy(tt.namespace, tt.svc); got == nil || !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("policy(%s, %s) = %+v, want %+v", tt.namespace, tt.svc, got, tt.want)
+		}
+	}
+
+	if err = os.WriteFile(path, []byte("services:\n  ocm:\n    waypoints: [two]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadUpgradePolicyConfig(path); err == nil {
+		t.Errorf("LoadUpgradePolicyConfig() with invalid waypoint error = nil")
+	}
+}
+
+func TestUseValidateUpdate_UpgradePolicy(t *testing.T) {
+	options := Options{UpgradePolicies: UpgradePolicyConfig{Default: &UpgradePolicy{ForbidDowngrade: true}}}
+	c := fake.NewClientBuilder().Build()
+
+	oldObj := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.4.0", "", nil)
+	newObj := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:1.9.0", "", nil)
+	if err := UseValidateUpdate(logr.Discard(), oldObj, newObj, c, options, context.Background()); err == nil {
+		t.Errorf("UseValidateUpdate() downgrade error = nil")
+	}
+}
@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	// K8sAnnotationWaitForDependencies 值为"true"时, 向工作负载注入init容器, 等待依赖的服务运行符合约束版本的就绪副本后再启动
+	K8sAnnotationWaitForDependencies = "dictator.wkm.welljoint.com/wait-for-dependencies"
+	// K8sAnnotationWaitTimeout 等待依赖就绪的超时时间, 如 10m, 未声明时使用WaitConfig.Timeout
+	K8sAnnotationWaitTimeout = "dictator.wkm.welljoint.com/wait-timeout"
+
+	WaitNamespaceEnv = "WKM_WAIT_NAMESPACE" // 等待依赖的容器所在的命名空间
+
+	WaitCAVolume    = "wkm-wait-ca"      // 挂载CA的卷
+	WaitCAMountPath = "/etc/wkm/wait-ca" // CA在init容器中的挂载目录
+	WaitCAKey       = "ca.crt"           // ConfigMap中保存CA的key, PEM格式
+)
+
+// WaitConfig 等待依赖就绪的init容器配置, Image和Endpoint均不为空时才注入
+type WaitConfig struct {
+	// init容器的镜像, 为dictator镜像, 以--wait-for-dependencies参数运行
+	Image string
+	// dictator API的地址, 如 https://dictator-webhook-service.dictator-system.svc
+	Endpoint string
+	// 保存校验dictator服务证书的CA的ConfigMap, 须存在于工作负载的命名空间, CA保存在ca.crt中
+	// 以卷挂载到init容器, 为空时使用系统CA
+	CAConfigMap string
+	// 默认的超时时间和查询间隔
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+func (c WaitConfig) Enabled() bool {
+	return c.Image != "" && c.Endpoint != ""
+}
+
+// WantsWaitForDependencies 工作负载是否要求等待依赖就绪
+func WantsWaitForDependencies(workload *registry.Workload) bool {
+	return workload.Meta.GetAnnotations()[K8sAnnotationWaitForDependencies] == "true"
+}
+
+// 生成等待依赖就绪的init容器
+func (c WaitConfig) container(workload *registry.Workload) (corev1.Container, error) {
+	timeout := c.Timeout
+	if raw := workload.Meta.GetAnnotations()[K8sAnnotationWaitTimeout]; raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return corev1.Container{}, fmt.Errorf("%s annotation的超时时间(%s)格式错误", K8sAnnotationWaitTimeout, raw)
+		}
+		timeout = d
+	}
+
+	container := corev1.Container{
+		Name:  registry.WaitContainerName,
+		Image: c.Image,
+		Args: []string{
+			"--wait-for-dependencies=" + workload.Meta.GetName(),
+			"--wait-endpoint=" + c.Endpoint,
+			"--wait-timeout=" + timeout.String(),
+			"--wait-interval=" + c.Interval.String(),
+		},
+		Env: []corev1.EnvVar{{
+			Name:      WaitNamespaceEnv,
+			ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+		}},
+	}
+	if c.CAConfigMap != "" {
+		container.Args = append(container.Args, "--wait-ca-file="+path.Join(WaitCAMountPath, WaitCAKey))
+		container.VolumeMounts = []corev1.VolumeMount{{Name: WaitCAVolume, MountPath: WaitCAMountPath, ReadOnly: true}}
+	}
+	return container, nil
+}
+
+// 挂载CA的卷, 未配置CAConfigMap时返回nil
+func (c WaitConfig) volume() *corev1.Volume {
+	if c.CAConfigMap == "" {
+		return nil
+	}
+	return &corev1.Volume{
+		Name: WaitCAVolume,
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: c.CAConfigMap},
+			Items:                []corev1.KeyToPath{{Key: WaitCAKey, Path: WaitCAKey}},
+		}},
+	}
+}
+
+// 要求等待依赖就绪时, 在init容器的最前面注入等待依赖的容器和挂载CA的卷, 否则移除已注入的容器和卷
+func injectWaitContainer(logger logr.Logger, wait WaitConfig, workload *registry.Workload) error {
+	wanted := wait.Enabled() && WantsWaitForDependencies(workload)
+	initContainers := make([]corev1.Container, 0, len(workload.Template.Spec.InitContainers)+1)
+	for _, c := range workload.Template.Spec.InitContainers {
+		if c.Name != registry.WaitContainerName {
+			initContainers = append(initContainers, c)
+		}
+	}
+	volumes := make([]corev1.Volume, 0, len(workload.Template.Spec.Volumes)+1)
+	for _, v := range workload.Template.Spec.Volumes {
+		if v.Name != WaitCAVolume {
+			volumes = append(volumes, v)
+		}
+	}
+	if !wanted && len(initContainers) == len(workload.Template.Spec.InitContainers) && len(volumes) == len(workload.Template.Spec.Volumes) {
+		return nil
+	}
+	if wanted {
+		container, err := wait.container(workload)
+		if err != nil {
+			return err
+		}
+		initContainers = append([]corev1.Container{container}, initContainers...)
+		if v := wait.volume(); v != nil {
+			volumes = append(volumes, *v)
+		}
+		logger.V(1).Info("已注入等待依赖就绪的容器")
+	}
+	if len(initContainers) == 0 {
+		initContainers = nil
+	}
+	if len(volumes) == 0 {
+		volumes = nil
+	}
+	workload.Template.Spec.InitContainers = initContainers
+	workload.Template.Spec.Volumes = volumes
+	return workload.Sync()
+}
+
+// CheckDependenciesReady 检查命名空间中服务name依赖的服务是否都有运行符合约束版本的就绪副本
+// 依赖以服务上的依赖约束annotation为准, 不存在的服务只在约束为required时视为未就绪
+// 未就绪时返回的错误说明原因, 服务不存在时返回NotFound
+func CheckDependenciesReady(ctx context.Context, myClient client.Client, logger logr.Logger, namespace, name string) error {
+	objsMap, err := ListWorkloads(ctx, myClient, logger, namespace)
+	if err != nil {
+		return err
+	}
+	obj := objsMap[name]
+	workload, ok := registry.GetWorkload(obj)
+	if !ok {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "workloads"}, name)
+	}
+
+	deps := make(map[string]string)
+	for svc, constraint := range registry.GetObjDependence(workload.Meta) {
+		if _, ok := registry.IsCapability(svc); ok {
+			continue
+		}
+		requirement, err := registry.ParseRequirement(constraint)
+		if err != nil {
+			return err
+		}
+		if objsMap[svc] == nil && requirement.Required {
+			return fmt.Errorf("依赖的服务%s不存在", svc)
+		}
+		deps[svc] = constraint
+	}
+	live, err := listLiveObjects(ctx, myClient, namespace)
+	if err != nil {
+		return err
+	}
+	return ReadinessRequirement{MinReady: 1}.check(live, objsMap, deps)
+}
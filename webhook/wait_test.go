@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/internal/testutil"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+	"time"
+)
+
+func TestInjectWaitContainer(t *testing.T) {
+	wait := WaitConfig{Image: "dictator:1.0.0", Endpoint: "https://dictator", CAConfigMap: "dictator-ca", Timeout: time.Minute, Interval: time.Second}
+	dataVolume := corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+
+	newObj := func(annotations map[string]string) *appsv1.Deployment {
+		obj := testutil.NewDeployment("wmc", unreachableImage, "1.8.1", nil)
+		obj.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+		obj.Spec.Template.Spec.Volumes = []corev1.Volume{dataVolume}
+		for k, v := range annotations {
+			obj.Annotations[k] = v
+		}
+		return obj
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantArgs    []string
+		wantErr     bool
+	}{
+		{name: "opt out"},
+		{
+			name:        "default timeout",
+			annotations: map[string]string{K8sAnnotationWaitForDependencies: "true"},
+			wantArgs:    []string{"--wait-for-dependencies=wmc", "--wait-endpoint=https://dictator", "--wait-timeout=1m0s", "--wait-interval=1s", "--wait-ca-file=/etc/wkm/wait-ca/ca.crt"},
+		},
+		{
+			name:        "annotation timeout",
+			annotations: map[string]string{K8sAnnotationWaitForDependencies: "true", K8sAnnotationWaitTimeout: "10m"},
+			wantArgs:    []string{"--wait-for-dependencies=wmc", "--wait-endpoint=https://dictator", "--wait-timeout=10m0s", "--wait-interval=1s", "--wait-ca-file=/etc/wkm/wait-ca/ca.crt"},
+		},
+		{
+			name:        "invalid timeout",
+			annotations: map[string]string{K8sAnnotationWaitForDependencies: "true", K8sAnnotationWaitTimeout: "soon"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := newObj(tt.annotations)
+			workload, _ := registry.GetWorkload(obj)
+			err := injectWaitContainer(logr.Discard(), wait, workload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("injectWaitContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			initContainers := obj.Spec.Template.Spec.InitContainers
+			if tt.wantArgs == nil {
+				if len(initContainers) != 1 {
+					t.Errorf("init containers = %v, want only init", initContainers)
+				}
+				return
+			}
+			if len(initContainers) != 2 || initContainers[0].Name != registry.WaitContainerName {
+				t.Fatalf("init containers = %v, want %s first", initContainers, registry.WaitContainerName)
+			}
+			if got := initContainers[0].Args; !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", got, tt.wantArgs)
+			}
+			// CA以ConfigMap卷挂载, 不写入环境变量
+			if env := initContainers[0].Env; len(env) != 1 || env[0].Name != WaitNamespaceEnv {
+				t.Errorf("env = %v, want only %s", env, WaitNamespaceEnv)
+			}
+			wantMounts := []corev1.VolumeMount{{Name: WaitCAVolume, MountPath: WaitCAMountPath, ReadOnly: true}}
+			if got := initContainers[0].VolumeMounts; !reflect.DeepEqual(got, wantMounts) {
+				t.Errorf("volume mounts = %v, want %v", got, wantMounts)
+			}
+			volumes := obj.Spec.Template.Spec.Volumes
+			if len(volumes) != 2 || volumes[1].Name != WaitCAVolume || volumes[1].ConfigMap == nil || volumes[1].ConfigMap.Name != "dictator-ca" {
+				t.Errorf("volumes = %v, want %s from dictator-ca", volumes, WaitCAVolume)
+			}
+			// 注入的容器不影响版本
+			if got := registry.GetPodVersion(&corev1.Pod{Spec: obj.Spec.Template.Spec}); got != "1.8.1" {
+				t.Errorf("version = %q, want 1.8.1", got)
+			}
+
+			// 重复注入时替换, 取消后移除
+			if err = injectWaitContainer(logr.Discard(), wait, workload); err != nil || len(obj.Spec.Template.Spec.InitContainers) != 2 ||
+				len(obj.Spec.Template.Spec.Volumes) != 2 {
+				t.Errorf("reinject init containers = %v, volumes = %v, err = %v", obj.Spec.Template.Spec.InitContainers, obj.Spec.Template.Spec.Volumes, err)
+			}
+			delete(obj.Annotations, K8sAnnotationWaitForDependencies)
+			if err = injectWaitContainer(logr.Discard(), wait, workload); err != nil || len(obj.Spec.Template.Spec.InitContainers) != 1 ||
+				!reflect.DeepEqual(obj.Spec.Template.Spec.Volumes, []corev1.Volume{dataVolume}) {
+				t.Errorf("opt out init containers = %v, volumes = %v, err = %v", obj.Spec.Template.Spec.InitContainers, obj.Spec.Template.Spec.Volumes, err)
+			}
+		})
+	}
+}
+
+func TestCheckDependenciesReady(t *testing.T) {
+	ocm := testutil.NewDeployment("ocm", "harbor:5000/wecloud/ocm:2.3.0", "", nil)
+	isController := true
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "ocm-new",
+			UID:             "ocm-new-uid",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "ocm", UID: ocm.UID, Controller: &isController}},
+		},
+		Status: appsv1.ReplicaSetStatus{Replicas: 1},
+	}
+	newPod := func(image string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "default",
+				Name:            "ocm-1",
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &isController}},
+			},
+			Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "ocm", Image: image}}},
+			Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		deps         map[string]string
+		objs         []client.Object
+		wantErr      bool
+		wantNotFound bool
+	}{
+		{name: "ready", deps: map[string]string{"ocm": "^2.0.0"}, objs: []client.Object{ocm, rs, newPod("harbor:5000/wecloud/ocm:2.3.0", corev1.ConditionTrue)}},
+		{name: "not ready", deps: map[string]string{"ocm": "^2.0.0"}, objs: []client.Object{ocm, rs, newPod("harbor:5000/wecloud/ocm:2.3.0", corev1.ConditionFalse)}, wantErr: true},
+		{name: "incompatible", deps: map[string]string{"ocm": "^3.0.0"}, objs: []client.Object{ocm, rs, newPod("harbor:5000/wecloud/ocm:2.3.0", corev1.ConditionTrue)}, wantErr: true},
+		{name: "optional missing", deps: map[string]string{"cms": "^4.0.0"}},
+		{name: "required missing", deps: map[string]string{"cms": "required:^4.0.0"}, wantErr: true},
+		{name: "capability ignored", deps: map[string]string{"cap_ocm.api": "required:^2.0"}},
+		{name: "workload missing", wantErr: true, wantNotFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := tt.objs
+			if !tt.wantNotFound {
+				objs = append(objs, testutil.NewDeployment("wmc", unreachableImage, "1.8.1", tt.deps))
+			}
+			c := fake.NewClientBuilder().WithObjects(objs...).Build()
+			err := CheckDependenciesReady(context.Background(), c, logr.Discard(), "default", "wmc")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckDependenciesReady() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if apierrors.IsNotFound(err) != tt.wantNotFound {
+				t.Errorf("CheckDependenciesReady() error = %v, wantNotFound %v", err, tt.wantNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+)
+
+type warningsKey struct{}
+
+// 准入过程中产生的警告, 不影响准入结果
+type admissionWarnings struct {
+	messages []string
+}
+
+// 返回收集警告的context, 检查结束后从返回的admissionWarnings中读取
+func withWarnings(ctx context.Context) (context.Context, *admissionWarnings) {
+	warnings := &admissionWarnings{}
+	return context.WithValue(ctx, warningsKey{}, warnings), warnings
+}
+
+// 记录警告, context不收集警告时忽略, 相同的警告只记录一次
+func addWarning(ctx context.Context, message string) {
+	warnings, ok := ctx.Value(warningsKey{}).(*admissionWarnings)
+	if !ok {
+		return
+	}
+	for _, m := range warnings.messages {
+		if m == message {
+			return
+		}
+	}
+	warnings.messages = append(warnings.messages, message)
+}
+
+// 将检查中产生的警告写入准入响应, kubectl等客户端会展示给请求方
+type warningHandler struct {
+	admission.Handler
+}
+
+func (h warningHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	ctx, warnings := withWarnings(ctx)
+	return h.Handler.Handle(ctx, req).WithWarnings(warnings.messages...)
+}
+
+// InjectDecoder 将decoder注入到被包装的handler
+func (h warningHandler) InjectDecoder(d *admission.Decoder) error {
+	_, err := admission.InjectDecoderInto(d, h.Handler)
+	return err
+}
+
+// 注册返回警告的validate webhook, 路径与controller-runtime生成的一致, 如 /validate-apps-v1-deployment
+// 与ctrl.NewWebhookManagedBy的WithValidator不同, 检查中产生的警告通过准入响应的warnings返回
+func registerValidator(mgr ctrl.Manager, obj runtime.Object, validator admission.CustomValidator) error {
+	gvk, err := apiutil.GVKForObject(obj, mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+	hook := admission.WithCustomValidator(obj, validator)
+	hook.Handler = warningHandler{Handler: hook.Handler}
+	path := "/validate-" + strings.ReplaceAll(gvk.Group, ".", "-") + "-" + gvk.Version + "-" + strings.ToLower(gvk.Kind)
+	mgr.GetWebhookServer().Register(path, hook)
+	return nil
+}
@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"gitlab.wellcloud.cc/cloud/dictator/registry"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+// WorkloadWebhook 自定义工作负载(如Argo Rollouts、OpenKruise CloneSet)的webhook, 对象以unstructured处理
+type WorkloadWebhook struct {
+	client  client.Client
+	logger  logr.Logger
+	options Options
+}
+
+func (w WorkloadWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	w.logger.Info("收到validate webhook创建请求")
+	return UseValidate(w.logger, obj, w.client, w.options, ctx)
+}
+
+func (w WorkloadWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	w.logger.Info("收到validate webhook更新请求")
+	return UseValidateUpdate(w.logger, oldObj, newObj, w.client, w.options, ctx)
+}
+
+func (w WorkloadWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	w.logger.Info("收到validate webhook删除请求")
+	return nil
+}
+
+func (w WorkloadWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	return defaultWorkload(ctx, w.client, w.logger, w.options, obj)
+}
+
+// SetupWorkloadWebhookWithManager 注册自定义工作负载的webhook
+// 路径与内置类型一致, 如 /mutate-argoproj-io-v1alpha1-rollout、/validate-argoproj-io-v1alpha1-rollout
+func SetupWorkloadWebhookWithManager(mgr ctrl.Manager, kind registry.WorkloadKind, options Options) error {
+	registry.RegisterWorkloadKind(kind)
+	hook := &WorkloadWebhook{
+		client:  mgr.GetClient(),
+		logger:  logf.Log.WithName("[webhook." + strings.ToLower(kind.Kind) + "]"),
+		options: options,
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(kind.GroupVersionKind())
+	if err := ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		WithDefaulter(hook).
+		Complete(); err != nil {
+		return err
+	}
+	return registerValidator(mgr, obj, hook)
+}
+
+// ListWorkloads 获取命名空间下所有的工作负载, 以名称为key
+func ListWorkloads(ctx context.Context, myClient client.Client, logger logr.Logger, namespace string) (map[string]runtime.Object, error) {
+	var deploymetObjs appsv1.DeploymentList
+	var statefulsetObjs appsv1.StatefulSetList
+	var daemonsetObjs appsv1.DaemonSetList
+	opts := client.ListOptions{
+		Namespace: namespace,
+	}
+	err := myClient.List(ctx, &deploymetObjs, &opts)
+	if err != nil {
+		logger.Info("获取所有Deployment资源失败", "err", err)
+		return nil, err
+	}
+	err = myClient.List(ctx, &statefulsetObjs, &opts)
+	if err != nil {
+		logger.Info("获取所有StatefulSet资源失败", "err", err)
+		return nil, err
+	}
+	err = myClient.List(ctx, &daemonsetObjs, &opts)
+	if err != nil {
+		logger.Info("获取所有DaemonSet资源失败", "err", err)
+		return nil, err
+	}
+
+	var objsMap = make(map[string]runtime.Object)
+	//拼接资源map
+	for i := range deploymetObjs.Items {
+		objsMap[deploymetObjs.Items[i].Name] = &deploymetObjs.Items[i]
+	}
+	for i := range statefulsetObjs.Items {
+		objsMap[statefulsetObjs.Items[i].Name] = &statefulsetObjs.Items[i]
+	}
+	for i := range daemonsetObjs.Items {
+		objsMap[daemonsetObjs.Items[i].Name] = &daemonsetObjs.Items[i]
+	}
+
+	for _, kind := range registry.WorkloadKinds() {
+		list := &unstructured.UnstructuredList{}
+		gvk := kind.GroupVersionKind()
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err = myClient.List(ctx, list, &opts); err != nil {
+			// 集群中未安装对应的CRD时跳过
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			logger.Info("获取所有"+kind.Kind+"资源失败", "err", err)
+			return nil, err
+		}
+		for i := range list.Items {
+			objsMap[list.Items[i].GetName()] = &list.Items[i]
+		}
+	}
+	return objsMap, nil
+}